@@ -0,0 +1,499 @@
+package qevix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+)
+
+//
+// Декларативное описание политики фильтрации.
+// Используется для загрузки и выгрузки конфигурации в формате JSON или YAML.
+// Callback-функции тегов и спецсимволов в описание не входят и задаются через Cfg* после загрузки.
+//
+type PolicySpec struct {
+	Tags             map[string]TagSpec           `json:"tags"`                         // Разрешённые теги и их правила
+	CutWithContent   []string                     `json:"cut_with_content,omitempty"`   // Теги, вырезаемые вместе с содержимым
+	EscapeTags       []string                     `json:"escape_tags,omitempty"`        // Запрещенные теги, выводимые как текст
+	LinkProtocols    []string                     `json:"link_protocols,omitempty"`     // Разрешенные схемы для ссылок
+	LinkHostBlock    []string                     `json:"link_host_block,omitempty"`    // Запрещенные домены ссылок
+	LinkHostInternal []string                     `json:"link_host_internal,omitempty"` // Собственные домены
+	LinkBaseURL      string                       `json:"link_base_url,omitempty"`      // Базовый адрес для относительных ссылок
+	StyleProperties  map[string][]string          `json:"style_properties,omitempty"`   // Разрешенные свойства встроенных стилей
+	TagRename        map[string]RenameSpec        `json:"tag_rename,omitempty"`         // Теги, переименовываемые до проверки правил
+	ParamRename      map[string]map[string]string `json:"param_rename,omitempty"`       // Параметры, переименовываемые до проверки правил
+	ClassPrefix      string                       `json:"class_prefix,omitempty"`       // Префикс классов
+	IDPrefix         string                       `json:"id_prefix,omitempty"`          // Префикс идентификаторов id и name
+	XHTMLMode        *bool                        `json:"xhtml_mode,omitempty"`         // Режим XHTML
+	AutoBrMode       *bool                        `json:"auto_br_mode,omitempty"`       // Авторасстановка тегов <br>
+	AutoLinkMode     *bool                        `json:"auto_link_mode,omitempty"`     // Автоподсветка ссылок
+	EscapeMode       bool                         `json:"escape_mode,omitempty"`        // Вывод всех запрещенных тегов как текста
+	DropErrors       bool                         `json:"drop_errors,omitempty"`        // Ошибки об удаленных правилами тегах и атрибутах
+	ExcerptEllipsis  *string                      `json:"excerpt_ellipsis,omitempty"`   // Многоточие обрезанного текста
+	CutTag           string                       `json:"cut_tag,omitempty"`            // Тег маркера ката
+	HeadingAnchors   bool                         `json:"heading_anchors,omitempty"`    // Якоря заголовков и оглавление
+	HeadingShift     int                          `json:"heading_shift,omitempty"`      // Сдвиг уровней заголовков
+	HeadingRange     []int                        `json:"heading_range,omitempty"`      // Диапазон уровней заголовков: [мин, макс]
+	EOL              string                       `json:"eol,omitempty"`                // Символы перевода строки
+}
+
+//
+// Декларативное описание правил одного тега
+//
+type TagSpec struct {
+	Short        bool `json:"short,omitempty"`         // Тег короткий
+	Preformatted bool `json:"preformatted,omitempty"`  // Тег преформатированный
+	NoTypography bool `json:"no_typography,omitempty"` // В теге отключено типографирование
+	Empty        bool `json:"empty,omitempty"`         // Тег может быть пустым
+	NoAutoBr     bool `json:"no_auto_br,omitempty"`    // В теге не нужна авторасстановка <br>
+	BlockType    bool `json:"block_type,omitempty"`    // Блочный тег
+	ParentOnly   bool `json:"parent_only,omitempty"`   // Тег может содержать только другие теги
+	ChildOnly    bool `json:"child_only,omitempty"`    // Тег может быть только дочерним
+	Global       bool `json:"global,omitempty"`        // Тег не может быть дочерним
+
+	Params   []string            `json:"params,omitempty"`   // Разрешённые параметры в порядке вывода
+	Required []string            `json:"required,omitempty"` // Обязательные параметры
+	Values   map[string][]string `json:"values,omitempty"`   // Допустимые значения параметров
+	Default  map[string]string   `json:"default,omitempty"`  // Значения параметров по умолчанию
+	Review   map[string]string   `json:"review,omitempty"`   // Значения параметров, заменяющие указанные
+	External map[string]string   `json:"external,omitempty"` // Значения параметров для внешних ссылок
+	Hosts    map[string][]string `json:"hosts,omitempty"`    // Разрешенные домены в ссылках параметров
+	Images   []string            `json:"images,omitempty"`   // Параметры со ссылками на изображения
+	Childs   []string            `json:"childs,omitempty"`   // Разрешённые дочерние теги
+}
+
+//
+// Декларативное описание переименования тега
+//
+type RenameSpec struct {
+	To     string            `json:"to"`               // Новый тег
+	Params map[string]string `json:"params,omitempty"` // Добавляемые параметры
+}
+
+//
+// Загружает конфигурацию из JSON или YAML.
+// Формат определяется по первому значащему символу: "{" — JSON, иначе YAML.
+// Загруженная конфигурация проверяется как методом Validate, кроме регистрации именованных шаблонов:
+// их функции задаются вызовами CfgSetParamValidator после загрузки.
+//
+// r io.Reader - источник
+//
+func LoadConfig(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		if data, err = yamlToJSON(data, reflect.TypeOf(PolicySpec{})); err != nil {
+			return nil, err
+		}
+	}
+
+	spec := PolicySpec{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, errors.New("qevix: " + err.Error())
+	}
+
+	cfg := New()
+	if err := cfg.CfgApplySpec(spec); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validate(false); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+//
+// Загружает конфигурацию из JSON или YAML и компилирует её в политику
+//
+// r io.Reader - источник
+//
+func LoadPolicy(r io.Reader) (*Policy, error) {
+	cfg, err := LoadConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Compile(), nil
+}
+
+//
+// КОНФИГУРАЦИЯ: Применяет декларативное описание политики.
+// Возвращает все ошибки, возникшие при применении.
+//
+// spec PolicySpec - описание политики
+//
+func (self *Config) CfgApplySpec(spec PolicySpec) error {
+	errs := ConfigErrors{}
+	collect := func(err error) {
+		if list, ok := err.(ConfigErrors); ok {
+			errs = append(errs, list...)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	tags := make([]string, 0, len(spec.Tags))
+	allowed := make([]string, 0, len(spec.Tags))
+	for tag := range spec.Tags {
+		tags = append(tags, tag)
+		if tag != "*" {
+			allowed = append(allowed, tag)
+		}
+	}
+	sort.Strings(tags)
+	sort.Strings(allowed)
+
+	collect(self.CfgAllowTags(allowed))
+
+	for _, tag := range tags {
+		ts := spec.Tags[tag]
+
+		flags := []struct {
+			isSet bool
+			set   func([]string) error
+		}{
+			{ts.Short, self.CfgSetTagShort},
+			{ts.Preformatted, self.CfgSetTagPreformatted},
+			{ts.NoTypography, self.CfgSetTagNoTypography},
+			{ts.Empty, self.CfgSetTagIsEmpty},
+			{ts.NoAutoBr, self.CfgSetTagNoAutoBr},
+			{ts.BlockType, self.CfgSetTagBlockType},
+			{ts.ParentOnly, self.CfgSetTagParentOnly},
+			{ts.ChildOnly, self.CfgSetTagChildOnly},
+			{ts.Global, self.CfgSetTagGlobal},
+		}
+		for _, flag := range flags {
+			if flag.isSet {
+				collect(flag.set([]string{tag}))
+			}
+		}
+
+		if len(ts.Params) > 0 {
+			collect(self.CfgAllowTagParams(tag, ts.Params))
+		}
+		if len(ts.Required) > 0 {
+			collect(self.CfgSetTagParamsRequired(tag, ts.Required))
+		}
+		params := make([]string, 0, len(ts.Values))
+		for param := range ts.Values {
+			params = append(params, param)
+		}
+		sort.Strings(params)
+		for _, param := range params {
+			collect(self.CfgAllowTagParamValue(tag, param, ts.Values[param]))
+		}
+		for param, value := range ts.Default {
+			collect(self.CfgSetTagParamDefault(tag, param, value))
+		}
+		for param, value := range ts.Review {
+			collect(self.CfgSetTagParamReview(tag, param, value))
+		}
+		for param, value := range ts.External {
+			collect(self.CfgSetTagParamExternal(tag, param, value))
+		}
+		params = params[:0]
+		for param := range ts.Hosts {
+			params = append(params, param)
+		}
+		sort.Strings(params)
+		for _, param := range params {
+			collect(self.CfgSetTagParamHostAllow(tag, param, ts.Hosts[param]))
+		}
+		if len(ts.Images) > 0 {
+			collect(self.CfgSetTagParamImage(tag, ts.Images))
+		}
+		if len(ts.Childs) > 0 {
+			collect(self.CfgSetTagChilds(tag, ts.Childs))
+		}
+	}
+
+	if len(spec.CutWithContent) > 0 {
+		collect(self.CfgSetTagCutWithContent(spec.CutWithContent))
+	}
+	if spec.LinkProtocols != nil {
+		collect(self.CfgSetLinkProtocolAllow(spec.LinkProtocols))
+	}
+	if len(spec.LinkHostBlock) > 0 {
+		collect(self.CfgSetLinkHostBlock(spec.LinkHostBlock))
+	}
+	if len(spec.LinkHostInternal) > 0 {
+		collect(self.CfgSetLinkHostInternal(spec.LinkHostInternal))
+	}
+	properties := make([]string, 0, len(spec.StyleProperties))
+	for property := range spec.StyleProperties {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+	for _, property := range properties {
+		collect(self.CfgAllowStyleProperty(property, spec.StyleProperties[property]))
+	}
+	renames := make([]string, 0, len(spec.TagRename)+len(spec.ParamRename))
+	for tag := range spec.TagRename {
+		renames = append(renames, tag)
+	}
+	sort.Strings(renames)
+	for _, tag := range renames {
+		rename := spec.TagRename[tag]
+		collect(self.CfgSetTagRename(tag, rename.To, rename.Params))
+	}
+	renames = renames[:0]
+	for tag := range spec.ParamRename {
+		renames = append(renames, tag)
+	}
+	sort.Strings(renames)
+	for _, tag := range renames {
+		params := make([]string, 0, len(spec.ParamRename[tag]))
+		for from := range spec.ParamRename[tag] {
+			params = append(params, from)
+		}
+		sort.Strings(params)
+		for _, from := range params {
+			collect(self.CfgSetParamRename(tag, from, spec.ParamRename[tag][from]))
+		}
+	}
+	if spec.IDPrefix != "" {
+		collect(self.CfgSetIDPrefix(spec.IDPrefix))
+	}
+	if spec.ClassPrefix != "" {
+		collect(self.CfgSetClassPrefix(spec.ClassPrefix))
+	}
+	if spec.LinkBaseURL != "" {
+		collect(self.CfgSetLinkBaseURL(spec.LinkBaseURL))
+	}
+	if spec.XHTMLMode != nil {
+		self.CfgSetXHTMLMode(*spec.XHTMLMode)
+	}
+	if spec.AutoBrMode != nil {
+		self.CfgSetAutoBrMode(*spec.AutoBrMode)
+	}
+	if spec.AutoLinkMode != nil {
+		self.CfgSetAutoLinkMode(*spec.AutoLinkMode)
+	}
+	if spec.EscapeMode {
+		self.CfgSetEscapeMode(true)
+	}
+	if spec.DropErrors {
+		self.CfgSetDropErrorsMode(true)
+	}
+	if len(spec.EscapeTags) > 0 {
+		collect(self.CfgSetTagEscape(spec.EscapeTags))
+	}
+	if spec.CutTag != "" {
+		collect(self.CfgSetCutTag(spec.CutTag))
+	}
+	if spec.ExcerptEllipsis != nil {
+		self.CfgSetExcerptEllipsis(*spec.ExcerptEllipsis)
+	}
+	if spec.HeadingAnchors {
+		self.CfgSetHeadingAnchors(true)
+	}
+	if spec.HeadingShift != 0 {
+		collect(self.CfgSetHeadingShift(spec.HeadingShift))
+	}
+	if len(spec.HeadingRange) == 2 {
+		collect(self.CfgSetHeadingRange(spec.HeadingRange[0], spec.HeadingRange[1]))
+	} else if spec.HeadingRange != nil {
+		collect(self.setError(&ConfigError{Method: "CfgApplySpec", Msg: "heading_range должен содержать два уровня"}))
+	}
+	if spec.EOL != "" {
+		collect(self.CfgSetEOL(spec.EOL))
+	}
+
+	return errs.err()
+}
+
+//
+// Возвращает декларативное описание политики
+//
+func (self *Policy) Spec() PolicySpec {
+	spec := PolicySpec{
+		Tags:             make(map[string]TagSpec, len(self.tagAllowed)),
+		CutWithContent:   sortedKeys(self.tagCutWithContent),
+		EscapeTags:       sortedKeys(self.tagEscape),
+		LinkProtocols:    append([]string{}, self.linkProtocolAllow...),
+		LinkHostBlock:    append([]string(nil), self.linkHostBlock...),
+		LinkHostInternal: append([]string(nil), self.linkHostInternal...),
+		LinkBaseURL:      self.linkBase,
+		ClassPrefix:      self.classPrefix,
+		IDPrefix:         self.idPrefix,
+		XHTMLMode:        boolPtr(self.isXHTMLMode),
+		AutoBrMode:       boolPtr(self.isAutoBrMode),
+		AutoLinkMode:     boolPtr(self.isAutoLinkMode),
+		EscapeMode:       self.isEscapeMode,
+		DropErrors:       self.isDropErrorsMode,
+		CutTag:           self.cutTag,
+		HeadingAnchors:   self.headingAnchors,
+		HeadingShift:     self.headingShift,
+		EOL:              self.nl,
+	}
+
+	if self.excerptEllipsis != "…" {
+		ellipsis := self.excerptEllipsis
+		spec.ExcerptEllipsis = &ellipsis
+	}
+
+	if self.headingMin != 1 || self.headingMax != 6 {
+		spec.HeadingRange = []int{self.headingMin, self.headingMax}
+	}
+
+	for tag := range self.tagAllowed {
+		spec.Tags[tag] = self.tagSpec(tag)
+	}
+
+	if len(self.styleProperties) > 0 {
+		spec.StyleProperties = make(map[string][]string, len(self.styleProperties))
+		for property, values := range self.styleProperties {
+			spec.StyleProperties[property] = append([]string(nil), values...)
+		}
+	}
+
+	if len(self.tagRename) > 0 {
+		spec.TagRename = make(map[string]RenameSpec, len(self.tagRename))
+		for tag, rename := range self.tagRename {
+			spec.TagRename[tag] = RenameSpec{To: rename.tag, Params: cloneStringMap(rename.params)}
+		}
+	}
+
+	if len(self.paramRename) > 0 {
+		spec.ParamRename = cloneStringMapMap(self.paramRename)
+	}
+
+	// Параметры всех тегов
+	if len(self.tagParamSorted["*"]) > 0 {
+		spec.Tags["*"] = self.tagSpec("*")
+	}
+
+	return spec
+}
+
+//
+// Возвращает декларативное описание правил одного тега
+//
+// tag string - тег
+//
+func (self *Policy) tagSpec(tag string) TagSpec {
+	ts := TagSpec{
+		Short:        self.tagShort[tag],
+		Preformatted: self.tagPreformatted[tag],
+		NoTypography: self.tagNoTypography[tag],
+		Empty:        self.tagEmpty[tag],
+		NoAutoBr:     self.tagNoAutoBr[tag],
+		BlockType:    self.tagBlockType[tag],
+		ParentOnly:   self.tagParentOnly[tag],
+		ChildOnly:    self.tagChildOnly[tag],
+		Global:       self.tagGlobalOnly[tag],
+
+		Params:   append([]string(nil), self.tagParamSorted[tag]...),
+		Required: sortedKeys(self.tagParamRequired[tag]),
+		Images:   sortedKeys(self.tagParamImage[tag]),
+		Childs:   sortedKeys(self.tagChild[tag]),
+	}
+
+	for param, values := range self.tagParamAllowed[tag] {
+		if len(values) == 1 && values[0] == "#str" {
+			continue
+		}
+		if ts.Values == nil {
+			ts.Values = make(map[string][]string)
+		}
+		ts.Values[param] = append([]string(nil), values...)
+	}
+
+	if len(self.tagParamDefault[tag]) > 0 {
+		ts.Default = make(map[string]string, len(self.tagParamDefault[tag]))
+		for param, value := range self.tagParamDefault[tag] {
+			ts.Default[param] = value
+		}
+	}
+
+	if len(self.tagParamReview[tag]) > 0 {
+		ts.Review = make(map[string]string, len(self.tagParamReview[tag]))
+		for param, value := range self.tagParamReview[tag] {
+			ts.Review[param] = value
+		}
+	}
+
+	if len(self.tagParamExternal[tag]) > 0 {
+		ts.External = make(map[string]string, len(self.tagParamExternal[tag]))
+		for param, value := range self.tagParamExternal[tag] {
+			ts.External[param] = value
+		}
+	}
+
+	if len(self.tagParamHosts[tag]) > 0 {
+		ts.Hosts = make(map[string][]string, len(self.tagParamHosts[tag]))
+		for param, hosts := range self.tagParamHosts[tag] {
+			ts.Hosts[param] = append([]string{}, hosts...)
+		}
+	}
+
+	return ts
+}
+
+//
+// Выгружает политику в формате JSON
+//
+// w io.Writer - приемник
+//
+func (self *Policy) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(self.Spec())
+}
+
+//
+// Выгружает политику в формате YAML
+//
+// w io.Writer - приемник
+//
+func (self *Policy) WriteYAML(w io.Writer) error {
+	data, err := json.Marshal(self.Spec())
+	if err != nil {
+		return err
+	}
+
+	data, err = jsonToYAML(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
+
+//
+// Отсортированный срез ключей карты флагов
+//
+func sortedKeys(m map[string]bool) []string {
+	if len(m) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+//
+// Указатель на копию значения флага
+//
+func boolPtr(v bool) *bool {
+	return &v
+}
@@ -0,0 +1,168 @@
+package qevix_test
+
+import (
+	"bytes"
+	"qevix"
+	"strings"
+	"testing"
+)
+
+const configYAML = `
+# Политика для комментариев
+tags:
+  a:
+    params: [href, title, rel]
+    required: [href]
+    values:
+      href: ["#link"]
+    review: {rel: nofollow}
+  b: {}
+  br:
+    short: true
+  ul:
+    parent_only: true
+    block_type: true
+    no_auto_br: true
+    childs:
+      - li
+  li:
+    child_only: true
+cut_with_content: [script, iframe] # вырезаются целиком
+link_protocols: # только веб-ссылки
+  - http
+  - https
+xhtml_mode: true
+`
+
+func TestLoadConfigN1(t *testing.T) {
+	policy, err := qevix.LoadPolicy(strings.NewReader(configYAML))
+	if err != nil {
+		t.Fatalf("Expect no error in func TestLoadConfigN1(t *testing.T).\n%s", err)
+	}
+
+	text := "<b>текст</b><br><a href=\"dighub.ru\" rel=\"me\">текст</a> <script>текст</script>\n<ul><li>текст</li><b>текст</b></ul>"
+
+	result, _ := policy.Parse(text)
+
+	expect := `<b>текст</b><br/>` + "\n"
+	expect += `<a href="http://dighub.ru" rel="nofollow">текст</a> <ul>` + "\n"
+	expect += `<li>текст</li>` + "\n"
+	expect += `</ul>`
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestLoadConfigN1(t *testing.T).\n%s", result)
+	}
+}
+
+func TestLoadConfigN2(t *testing.T) {
+	policy, err := qevix.LoadPolicy(strings.NewReader(configYAML))
+	if err != nil {
+		t.Fatalf("Expect no error in func TestLoadConfigN2(t *testing.T).\n%s", err)
+	}
+
+	for _, write := range []func(*qevix.Policy, *bytes.Buffer) error{
+		func(p *qevix.Policy, b *bytes.Buffer) error { return p.WriteJSON(b) },
+		func(p *qevix.Policy, b *bytes.Buffer) error { return p.WriteYAML(b) },
+	} {
+		first := bytes.NewBufferString("")
+		if err := write(policy, first); err != nil {
+			t.Fatalf("Expect no error in func TestLoadConfigN2(t *testing.T).\n%s", err)
+		}
+
+		loaded, err := qevix.LoadPolicy(bytes.NewReader(first.Bytes()))
+		if err != nil {
+			t.Fatalf("Expect no error in func TestLoadConfigN2(t *testing.T).\n%s\n%s", err, first)
+		}
+
+		second := bytes.NewBufferString("")
+		write(loaded, second)
+
+		if first.String() != second.String() {
+			t.Errorf("Expect dump to equal in func TestLoadConfigN2(t *testing.T).\n%s\n%s", first, second)
+		}
+	}
+}
+
+func TestLoadConfigN3(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"a"})
+	cfg.CfgAllowTagParams("a", []string{"href"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+
+	buff := bytes.NewBufferString("")
+	cfg.WriteYAML(buff)
+
+	expect := `tags:
+  a:
+    params: ["href"]
+    values:
+      href: ["#link"]
+link_protocols: ["http", "https", "ftp"]
+xhtml_mode: false
+auto_br_mode: true
+auto_link_mode: true
+eol: "\n"
+`
+
+	if buff.String() != expect {
+		t.Errorf("Expect dump to equal in func TestLoadConfigN3(t *testing.T).\n%s", buff)
+	}
+}
+
+func TestLoadConfigN4(t *testing.T) {
+	sources := []string{
+		`{"tags": {"ul": {"childs": ["li"]}}}`,
+		`{"tags": {"a": {"values": {"href": ["#link"]}}}}`,
+		`{"tags": {}, "unknown": true}`,
+		"tags:\n  a:\n    params: [href\n",
+		"tags:\n  a:\n     params: []\n    short: true\n",
+		"tags:\n  a:\n    params: [href]\n    values:\n      href: #link\n",
+		"tags:\n  a:\n    params: [href]\n    values:\n      href:\n        - #link\n",
+		"tags:\n  a:\n    params: [href]\n    values:\n      href: [#regexp(^x$), #link]\n",
+	}
+
+	for _, source := range sources {
+		if _, err := qevix.LoadConfig(strings.NewReader(source)); err == nil {
+			t.Errorf("Expect error in func TestLoadConfigN4(t *testing.T).\n%s", source)
+		}
+	}
+}
+
+func TestLoadConfigN5(t *testing.T) {
+	source := `
+tags:
+  td:
+    params: [colspan]
+    default: {colspan: 2}
+  h3: {}
+heading_shift: 1
+heading_range: [2, 5]
+`
+
+	policy, err := qevix.LoadPolicy(strings.NewReader(source))
+	if err != nil {
+		t.Fatalf("Expect no error in func TestLoadConfigN5(t *testing.T).\n%s", err)
+	}
+
+	first := bytes.NewBufferString("")
+	policy.WriteYAML(first)
+
+	loaded, err := qevix.LoadPolicy(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatalf("Expect no error in func TestLoadConfigN5(t *testing.T).\n%s\n%s", err, first)
+	}
+
+	second := bytes.NewBufferString("")
+	loaded.WriteYAML(second)
+
+	if first.String() != second.String() || !strings.Contains(first.String(), "heading_shift: 1\nheading_range: [2, 5]\n") {
+		t.Errorf("Expect dump to equal in func TestLoadConfigN5(t *testing.T).\n%s\n%s", first, second)
+	}
+
+	result, _ := loaded.Parse("<h2>текст</h2><td>текст</td>")
+	expect := `<h3>текст</h3><td colspan="2">текст</td>`
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestLoadConfigN5(t *testing.T).\n%s", result)
+	}
+}
@@ -0,0 +1,156 @@
+package qevix
+
+import (
+	"strings"
+	"unicode"
+)
+
+//
+// Маркер ката, найденный при парсинге
+//
+type cutMarker struct {
+	pos    Position // Позиция маркера
+	nested bool     // Маркер вложен в другой маркер
+}
+
+//
+// Обрабатывает маркер ката: выводит метку для разделения текста, содержимое маркера хранится в дочерних узлах метки
+// и выводится следом за ней. Какой маркер разделяет текст, решается в resolveCut, когда известно, какие маркеры
+// остались в тексте, там же содержимое выносится за метку.
+//
+// tagContent []*Node - контент тега, если маркер не короткий
+// tagPos int - позиция тега
+//
+func (self *parser) makeCut(tagContent []*Node, tagPos int) []*Node {
+	if self.cutMarkers == nil {
+		self.cutMarkers = make(map[*Node]cutMarker)
+	}
+
+	marker := &Node{Kind: NodeElement, Tag: self.cutTag, Short: true, Children: tagContent}
+	self.cutMarkers[marker] = cutMarker{pos: self.position(tagPos), nested: self.inCut > 0}
+
+	return []*Node{marker}
+}
+
+//
+// Выбирает маркер ката среди маркеров, оставшихся в тексте: первый маркер запоминается,
+// повторные и вложенные удаляются с ошибкой. Маркеры внутри удаленных тегов в тексте не остаются.
+// Содержимое маркеров выносится за метку на тот же уровень за один проход.
+//
+// nodes []*Node - узлы
+//
+func (self *parser) resolveCut(nodes []*Node) []*Node {
+	result := nodeList{}
+	dropped := false
+
+	var resolve func(nodes []*Node)
+	resolve = func(nodes []*Node) {
+		for _, node := range nodes {
+			marker, ok := self.cutMarkers[node]
+			if !ok {
+				if node.Kind == NodeElement {
+					node.Children = self.resolveCut(node.Children)
+				}
+				if dropped && node.Kind == NodeText && result.text.Len() > 0 {
+					node.Text = joinCutText(result.text.String(), node.Text)
+				}
+				dropped = false
+				result.add(node)
+				continue
+			}
+
+			content := node.Children
+			node.Children = nil
+
+			pos := marker.pos
+			if self.cut == nil {
+				self.cut = &pos
+				dropped = false
+				result.add(node)
+			} else {
+				self.setError(&CutMarkerError{Tag: self.cutTag, Nested: marker.nested, Pos: pos})
+				self.setReport(ReportEntry{Kind: KindTag, Action: ActionUnwrapped, Tag: self.cutTag, Reason: ReasonCutMarker, Pos: pos})
+				dropped = true
+			}
+
+			resolve(content)
+		}
+	}
+	resolve(nodes)
+
+	return result.result()
+}
+
+//
+// Разделяет узлы по маркеру ката на анонс и полный текст без маркера
+//
+// nodes []*Node - узлы с маркером
+//
+func (self *parser) splitCut(nodes []*Node) (string, []*Node) {
+	teaser, full, _ := splitCutNodes(nodes, self.cutTag)
+
+	// Анонс и полный текст разделяют узлы, поэтому анонс обрезается на копии
+	teaser = trimExcerpt(cloneNodes(teaser))
+
+	return strings.TrimSpace(self.Render(teaser)), trimNodes(full)
+}
+
+//
+// Разделяет узлы по маркеру ката: узлы до маркера с закрытыми тегами и все узлы без маркера
+//
+// nodes []*Node - узлы
+// tag string - тег маркера ката
+//
+func splitCutNodes(nodes []*Node, tag string) ([]*Node, []*Node, bool) {
+	teaser := []*Node{}
+	full := []*Node{}
+
+	for i, node := range nodes {
+		if node.Kind != NodeElement {
+			teaser = append(teaser, node)
+			full = append(full, node)
+			continue
+		}
+
+		if node.Tag == tag {
+			rest := nodes[i+1:]
+
+			if len(full) > 0 && len(rest) > 0 && full[len(full)-1].Kind == NodeText && rest[0].Kind == NodeText {
+				prev := full[len(full)-1]
+				full = append(full[:len(full)-1:len(full)-1], &Node{Kind: NodeText, Text: prev.Text + joinCutText(prev.Text, rest[0].Text)})
+				rest = rest[1:]
+			}
+
+			return teaser, append(full, rest...), true
+		}
+
+		childTeaser, childFull, found := splitCutNodes(node.Children, tag)
+		if !found {
+			teaser = append(teaser, node)
+			full = append(full, node)
+			continue
+		}
+
+		teaserNode := *node
+		teaserNode.Children = childTeaser
+		fullNode := *node
+		fullNode.Children = childFull
+
+		return append(teaser, &teaserNode), append(append(full, &fullNode), nodes[i+1:]...), true
+	}
+
+	return teaser, full, false
+}
+
+//
+// Возвращает текст после удаленного маркера ката: если перед маркером есть пробел, пробелы после него удаляются
+//
+// prev string - текст перед маркером
+// next string - текст после маркера
+//
+func joinCutText(prev string, next string) string {
+	if strings.TrimRightFunc(prev, unicode.IsSpace) != prev {
+		return strings.TrimLeftFunc(next, unicode.IsSpace)
+	}
+	return next
+}
@@ -0,0 +1,105 @@
+package qevix_test
+
+import (
+	"qevix"
+	"testing"
+)
+
+var cutQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgAllowTags([]string{"b", "p", "cut"})
+	cfg.CfgSetTagBlockType([]string{"p"})
+	cfg.CfgSetTagShort([]string{"cut"})
+	cfg.CfgSetCutTag("cut")
+})
+
+func TestCutN1(t *testing.T) {
+	tests := []struct {
+		text   string
+		teaser string
+		expect string
+	}{
+		{`<p>Анонс</p><cut><p>Текст</p>`, `<p>Анонс</p>`, "<p>Анонс</p>\n<p>Текст</p>"},
+		{`<p>a <b>b <cut>c</b> d</p>`, `<p>a <b>b</b></p>`, `<p>a <b>b c</b> d</p>`},
+		{`<p>Без ката</p>`, ``, `<p>Без ката</p>`},
+	}
+
+	for _, test := range tests {
+		result := cutQvx.ParseResult(test.text)
+
+		if result.Teaser != test.teaser || result.Content != test.expect || len(result.Errors) > 0 {
+			t.Errorf("Expect result to equal in func TestCutN1(t *testing.T).\n%s: %q %q %v", test.text, result.Teaser, result.Content, result.Errors)
+		}
+	}
+
+	result := cutQvx.ParseResult("<p>Анонс</p>\n<cut>")
+	if result.Cut == nil || result.Cut.Line != 2 || result.Cut.Column != 1 {
+		t.Errorf("Expect result to equal in func TestCutN1(t *testing.T).\n%v", result.Cut)
+	}
+}
+
+func TestCutN2(t *testing.T) {
+	// Маркер, который не объявлен коротким тегом, может содержать текст
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"b", "p"})
+	cfg.CfgSetTagBlockType([]string{"p"})
+	cfg.CfgSetCutTag("cut")
+
+	tests := []struct {
+		qvx    *qevix.Config
+		text   string
+		teaser string
+		nested bool
+	}{
+		{cutQvx, `<p>a</p><cut><p>b</p><cut><p>c</p>`, `<p>a</p>`, false},
+		{cutQvx, `<b>a</b> <cut> <b>b <cut> c</b>`, `<b>a</b>`, false},
+		{cfg, `<p>a</p><cut><cut>b</cut></cut>`, `<p>a</p>`, true},
+	}
+
+	for _, test := range tests {
+		result := test.qvx.ParseResult(test.text)
+
+		if len(result.Errors) != 1 || result.Teaser != test.teaser {
+			t.Errorf("Expect result to equal in func TestCutN2(t *testing.T).\n%s: %q %v", test.text, result.Teaser, result.Errors)
+			continue
+		}
+		if err, ok := result.Errors[0].(*qevix.CutMarkerError); !ok || err.Nested != test.nested {
+			t.Errorf("Expect result to equal in func TestCutN2(t *testing.T).\n%s: %v", test.text, result.Errors[0])
+		}
+	}
+
+	if err := qevix.New().CfgSetCutTag("cut me"); err == nil {
+		t.Errorf("Expect result to equal in func TestCutN2(t *testing.T).\n%v", err)
+	}
+}
+
+func TestCutN3(t *testing.T) {
+	// Маркер внутри тега, удаляемого вместе с содержимым, не разделяет текст
+	cfg := qevix.New()
+	cfg.CfgApplySpec(cutQvx.Spec())
+	cfg.CfgSetTagGlobal([]string{"cut"})
+	cfg.CfgSetTagCutWithContent([]string{"script"})
+
+	tests := []struct {
+		text   string
+		teaser string
+		expect string
+		errors int
+	}{
+		{`intro <script><cut></script> more <cut> rest`, `intro more`, `intro more rest`, 0},
+		{`a <cut> b c`, `a`, `a b c`, 0},
+		{`a <cut> b <cut> c`, `a`, `a b c`, 1},
+	}
+
+	for _, test := range tests {
+		result := cfg.ParseResult(test.text)
+
+		if result.Teaser != test.teaser || result.Content != test.expect || len(result.Errors) != test.errors {
+			t.Errorf("Expect result to equal in func TestCutN3(t *testing.T).\n%s: %q %q %v", test.text, result.Teaser, result.Content, result.Errors)
+		}
+	}
+
+	result := cfg.ParseResult(`intro <script><cut></script> more <cut> rest`)
+	if result.Cut == nil || result.Cut.Line != 1 || result.Cut.Column != 35 {
+		t.Errorf("Expect result to equal in func TestCutN3(t *testing.T).\n%v", result.Cut)
+	}
+}
@@ -0,0 +1,318 @@
+package qevix
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+//
+// Версия модели документа
+//
+const DocumentVersion = 1
+
+//
+// Теги, которые в модели документа становятся отметками текста, если содержат только текст
+//
+var markTags = map[string]bool{
+	"a": true, "abbr": true, "b": true, "code": true, "del": true, "em": true, "i": true, "ins": true,
+	"kbd": true, "mark": true, "q": true, "s": true, "small": true, "span": true, "strike": true,
+	"strong": true, "sub": true, "sup": true, "u": true,
+}
+
+//
+// Модель отфильтрованного документа для сериализации в JSON.
+// Текст хранится без экранирования, с уже примененной типографикой.
+// Уровни заголовков хранятся без сдвига CfgSetHeadingShift: при сборке документа заголовки сдвигаются снова.
+//
+type Document struct {
+	Version int       `json:"version"`
+	Content []DocNode `json:"content"`
+}
+
+//
+// Узел документа: текст с отметками или тег с атрибутами и дочерними узлами
+//
+type DocNode struct {
+	Type    string            `json:"type"`              // "text" или тег
+	Text    string            `json:"text,omitempty"`    // Текст, для "text"
+	Marks   []DocMark         `json:"marks,omitempty"`   // Отметки текста от внешней к внутренней, для "text"
+	Attrs   map[string]string `json:"attrs,omitempty"`   // Атрибуты тега
+	Content []DocNode         `json:"content,omitempty"` // Дочерние узлы тега
+}
+
+//
+// Отметка текста: строчный тег (b, em, a...) с атрибутами
+//
+type DocMark struct {
+	Type  string            `json:"type"`            // Тег
+	Attrs map[string]string `json:"attrs,omitempty"` // Атрибуты тега
+}
+
+//
+// Парсинг строки в модель документа
+//
+// Вызов безопасен из нескольких горутин
+//
+// text string - входная строка для парсинга
+//
+func (self *Policy) ParseDocument(text string) (*Document, []error) {
+	nodes, errs := self.ParseTree(text)
+	return &Document{Version: DocumentVersion, Content: self.docContent(nodes, "")}, errs
+}
+
+//
+// Собирает HTML из модели документа. Документ проверяется правилами политики так же,
+// как текст при парсинге: запрещенные теги и атрибуты удаляются, ссылки разрешаются, проверяются
+// и перезаписываются, заголовки сдвигаются.
+//
+// Вызов безопасен из нескольких горутин
+//
+// doc *Document - документ
+//
+func (self *Policy) RenderDocument(doc *Document) (Result, error) {
+	if doc.Version != DocumentVersion {
+		return Result{}, errors.New("неподдерживаемая версия документа " + strconv.Itoa(doc.Version))
+	}
+
+	p := self.pool.Get().(*parser)
+	defer self.pool.Put(p)
+
+	p.reset("")
+
+	result, _ := p.result(p.importContent(doc.Content, ""))
+
+	return result, nil
+}
+
+//
+// Преобразует узлы дерева в узлы документа.
+// Переводы строк, которые добавляются при сборке тегов, удаляются: при обратной сборке они добавятся снова.
+//
+// nodes []*Node - узлы
+// parent string - родительский тег
+//
+func (self *Policy) docContent(nodes []*Node, parent string) []DocNode {
+	content := []DocNode{}
+
+	skip := 0
+	if self.tagParentOnly[parent] {
+		skip = 1
+	}
+
+	for _, node := range nodes {
+		if node.Kind == NodeText {
+			text := node.Text
+			for ; skip > 0 && strings.HasPrefix(text, "\n"); skip-- {
+				text = text[1:]
+			}
+			if text != "" {
+				content = append(content, DocNode{Type: "text", Text: text})
+			}
+			skip = 0
+			continue
+		}
+
+		// HTML callback-функции сохраняется тегом, из которого собран, или исходным текстом спецсимвола
+		if node.Kind == NodeRaw {
+			if node.Tag == "" {
+				content = append(content, self.docContent(node.Children, "")...)
+				skip = 0
+				continue
+			}
+			element := *node
+			element.Kind = NodeElement
+			node = &element
+		}
+
+		if isMarkNode(node) {
+			content = appendMarked(content, node, nil)
+		} else {
+			content = append(content, DocNode{Type: self.docTag(node.Tag), Attrs: docAttrs(node.Attrs), Content: self.docContent(node.Children, node.Tag)})
+		}
+
+		skip = 0
+		if self.tagParentOnly[parent] {
+			skip++
+		}
+		if self.tagBlockType[node.Tag] {
+			skip++
+		}
+		if node.Tag == "br" {
+			skip++
+		}
+	}
+
+	return content
+}
+
+//
+// Тег для документа: заголовок возвращается на уровень до сдвига, чтобы повторный сдвиг при сборке
+// дал тот же уровень. Уровень, ограниченный диапазоном, сдвигается обратно не ниже h1.
+//
+// tag string - тег
+//
+func (self *Policy) docTag(tag string) string {
+	level := headingLevel(tag)
+	if level == 0 || self.headingShift == 0 {
+		return tag
+	}
+
+	if level -= self.headingShift; level < 1 {
+		level = 1
+	}
+	return "h" + strconv.Itoa(level)
+}
+
+//
+// Можно ли представить тег отметками текста: строчный тег, содержащий только текст и другие строчные теги
+//
+// node *Node - узел
+//
+func isMarkNode(node *Node) bool {
+	if node.Kind != NodeElement || !markTags[node.Tag] || node.Short {
+		return false
+	}
+
+	hasText := false
+	for _, child := range node.Children {
+		if child.Kind == NodeText {
+			hasText = true
+		} else if !isMarkNode(child) {
+			return false
+		} else {
+			hasText = true
+		}
+	}
+	return hasText
+}
+
+//
+// Добавляет текст строчного тега с отметками
+//
+// content []DocNode - узлы документа
+// node *Node - строчный тег
+// marks []DocMark - отметки внешних тегов
+//
+func appendMarked(content []DocNode, node *Node, marks []DocMark) []DocNode {
+	marks = append(marks[:len(marks):len(marks)], DocMark{Type: node.Tag, Attrs: docAttrs(node.Attrs)})
+
+	for _, child := range node.Children {
+		if child.Kind == NodeText {
+			content = append(content, DocNode{Type: "text", Text: child.Text, Marks: marks})
+		} else {
+			content = appendMarked(content, child, marks)
+		}
+	}
+	return content
+}
+
+//
+// Атрибуты тега для документа
+//
+// attrs []Attr - атрибуты
+//
+func docAttrs(attrs []Attr) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		result[attr.Name] = attr.Value
+	}
+	return result
+}
+
+//
+// Собирает узлы документа, проверяя теги и атрибуты правилами политики
+//
+// nodes []DocNode - узлы
+// parentTag string - родительский тег
+//
+func (self *parser) importContent(nodes []DocNode, parentTag string) []*Node {
+	content := nodeList{}
+
+	for i := 0; i < len(nodes); i++ {
+		node := nodes[i]
+
+		// Текст с отметками: соседние узлы с одинаковой внешней отметкой собираются в один тег
+		if node.Type == "text" && len(node.Marks) > 0 {
+			mark := node.Marks[0]
+			run := []DocNode{}
+			for ; i < len(nodes) && nodes[i].Type == "text" && len(nodes[i].Marks) > 0 && equalMarks(nodes[i].Marks[0], mark); i++ {
+				inner := nodes[i]
+				inner.Marks = inner.Marks[1:]
+				run = append(run, inner)
+			}
+			i--
+
+			content.add(self.importTag(mark.Type, mark.Attrs, self.importContent(run, mark.Type), parentTag)...)
+			continue
+		}
+
+		switch {
+		// В теге, который может содержать только другие теги, текст не допускается
+		case node.Type == "text" && self.tagParentOnly[parentTag]:
+		case node.Type == "text":
+			content.addText(node.Text)
+		// Перевод строки, расставленный автоматически
+		case node.Type == "br" && !self.tagAllowed["br"]:
+			if _, ok := self.tagNoAutoBr[parentTag]; self.isAutoBrMode && !ok {
+				content.add(&Node{Kind: NodeElement, Tag: "br", Short: true})
+				content.addText("\n")
+			}
+		default:
+			content.add(self.importTag(node.Type, node.Attrs, self.importContent(node.Content, node.Type), parentTag)...)
+		}
+	}
+
+	return content.result()
+}
+
+//
+// Собирает тег документа по правилам политики
+//
+// tag string - тег
+// attrs map[string]string - атрибуты без экранирования
+// content []*Node - собранное содержимое тега
+// parentTag string - родительский тег
+//
+func (self *parser) importTag(tag string, attrs map[string]string, content []*Node, parentTag string) []*Node {
+	tag = strings.ToLower(tag)
+
+	params := make(map[string]string, len(attrs))
+	for name, value := range attrs {
+		if !paramNameRx.MatchString(name) {
+			pos := self.position(0)
+			self.setDropError(&DroppedParamError{Tag: tag, Param: name, Value: value, Pos: pos})
+			self.setReport(ReportEntry{Kind: KindParam, Action: ActionRemoved, Tag: tag, Param: name, Value: value, Reason: ReasonNotAllowed, Pos: pos})
+			continue
+		}
+		params[name] = escapeValue(self.rules, value)
+	}
+
+	tag, params, _ = self.canonicalTag(tag, params, nil, 0)
+
+	_, shortTag := self.tagShort[tag]
+	if shortTag {
+		content = nil
+	}
+
+	return self.makeTag(tag, params, content, tagMarkup{}, shortTag, parentTag, 0, nil)
+}
+
+//
+// Сравнивает отметки текста
+//
+func equalMarks(a DocMark, b DocMark) bool {
+	if a.Type != b.Type || len(a.Attrs) != len(b.Attrs) {
+		return false
+	}
+	for name, value := range a.Attrs {
+		if other, ok := b.Attrs[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
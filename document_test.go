@@ -0,0 +1,188 @@
+package qevix_test
+
+import (
+	"encoding/json"
+	"qevix"
+	"strings"
+	"testing"
+)
+
+var documentQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgAllowTags([]string{"a", "b", "i", "p", "img", "ul", "li", "h2", "pre", "code"})
+	cfg.CfgSetTagShort([]string{"img"})
+	cfg.CfgSetTagBlockType([]string{"p", "ul", "h2", "pre"})
+	cfg.CfgSetTagPreformatted([]string{"pre"})
+	cfg.CfgAllowTagParams("a", []string{"href"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+	cfg.CfgAllowTagParams("img", []string{"src", "alt"})
+	cfg.CfgAllowTagParamValue("img", "src", "#link")
+	cfg.CfgAllowTagParams("code", []string{"class"})
+	cfg.CfgAllowTagParamValue("code", "class", "#class(language-*)")
+	cfg.CfgSetClassPrefix("user-")
+	cfg.CfgSetTagChilds("ul", []string{"li"})
+	cfg.CfgSetTagParentOnly([]string{"ul"})
+	cfg.CfgSetTagChildOnly([]string{"li"})
+	cfg.CfgSetHeadingAnchors(true)
+	cfg.CfgSetImageProxy("https://camo.dighub.ru", []byte("secret"), "sha1")
+})
+
+func TestDocumentN1(t *testing.T) {
+	texts := []string{
+		`<p>Текст "в кавычках" - <b>жирный <i>курсив</i></b> и <a href="http://dighub.ru/?a=1&b=2">ссылка</a></p>`,
+		"строка 1\nстрока 2\n\nабзац <img src=\"http://example.com/i.png\" alt='<x>'>",
+		"<ul><li>a</li><li>b <b>c</b></li></ul>\n<h2>Заголовок</h2>\n<h2>Заголовок</h2>",
+		"<pre><code class=\"language-go\">a < b\n  c</code></pre>",
+	}
+
+	for _, text := range texts {
+		expect, _ := documentQvx.Parse(text)
+		doc, _ := documentQvx.ParseDocument(text)
+
+		data, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatalf("Expect no error in func TestDocumentN1(t *testing.T).\n%v", err)
+		}
+
+		restored := &qevix.Document{}
+		if err := json.Unmarshal(data, restored); err != nil {
+			t.Fatalf("Expect no error in func TestDocumentN1(t *testing.T).\n%v", err)
+		}
+
+		result, err := documentQvx.RenderDocument(restored)
+		if err != nil || result.Content != expect {
+			t.Errorf("Expect result to equal in func TestDocumentN1(t *testing.T).\n%s\n%s", expect, result.Content)
+		}
+	}
+}
+
+func TestDocumentN2(t *testing.T) {
+	doc, _ := documentQvx.ParseDocument(`<p>a <b>b <i>c</i></b> <a href="/d">d</a></p>`)
+
+	data, _ := json.Marshal(doc)
+
+	expect := `{"version":1,"content":[{"type":"p","content":[{"type":"text","text":"a "},{"type":"text","text":"b ","marks":[{"type":"b"}]},{"type":"text","text":"c","marks":[{"type":"b"},{"type":"i"}]},{"type":"text","text":" "},{"type":"text","text":"d","marks":[{"type":"a","attrs":{"href":"/d"}}]}]}]}`
+
+	if string(data) != expect {
+		t.Errorf("Expect json to equal in func TestDocumentN2(t *testing.T).\n%s", data)
+	}
+}
+
+func TestDocumentN3(t *testing.T) {
+	data := `{"version":1,"content":[
+		{"type":"script","content":[{"type":"text","text":"alert(1)"}]},
+		{"type":"p","attrs":{"onclick":"x","a onclick":"y"},"content":[
+			{"type":"text","text":"<b>","marks":[{"type":"a","attrs":{"href":"javascript:alert(1)"}}]},
+			{"type":"text","text":"ok","marks":[{"type":"u"}]}
+		]}
+	]}`
+
+	doc := &qevix.Document{}
+	if err := json.Unmarshal([]byte(data), doc); err != nil {
+		t.Fatalf("Expect no error in func TestDocumentN3(t *testing.T).\n%v", err)
+	}
+
+	result, err := documentQvx.RenderDocument(doc)
+
+	expect := `alert(1)<p><a>&#60;b&#62;</a>ok</p>`
+
+	if err != nil || result.Content != expect {
+		t.Errorf("Expect result to equal in func TestDocumentN3(t *testing.T).\n%s", result.Content)
+	}
+
+	if _, err := documentQvx.RenderDocument(&qevix.Document{Version: 2}); err == nil {
+		t.Errorf("Expect error in func TestDocumentN3(t *testing.T).\n%d", 2)
+	}
+}
+
+func TestDocumentN4(t *testing.T) {
+	// Ссылки модели документа проверяются и перезаписываются снова, перезапись не меняет уже перезаписанную ссылку
+	cfg := qevix.New()
+	cfg.CfgApplySpec(documentQvx.Spec())
+	cfg.CfgSetLinkBaseURL("https://dighub.ru/blog/")
+	cfg.CfgSetLinkRewriteCallback(func(tag string, param string, link string, external bool) string {
+		if external && !strings.HasPrefix(link, "https://dighub.ru/away?") {
+			return "https://dighub.ru/away?to=" + link
+		}
+		return link
+	})
+
+	texts := []string{
+		`<p><a href="http://example.com/">внешняя</a> и <a href="post">внутренняя</a></p>`,
+		`<img src="http://example.com/i.png">`,
+	}
+
+	for _, text := range texts {
+		expect, _ := cfg.Parse(text)
+		doc, _ := cfg.ParseDocument(text)
+
+		result, err := cfg.RenderDocument(doc)
+		if err != nil || result.Content != expect {
+			t.Errorf("Expect result to equal in func TestDocumentN4(t *testing.T).\n%s\n%s", expect, result.Content)
+		}
+	}
+}
+
+func TestDocumentN5(t *testing.T) {
+	// Документ от клиента проверяется так же, как текст: сдвиг заголовков, базовый адрес, запрещенные домены,
+	// перезапись и отклонение ссылок, прокси изображений
+	cfg := qevix.New()
+	cfg.CfgApplySpec(documentQvx.Spec())
+	cfg.CfgAllowTags([]string{"h3"})
+	cfg.CfgSetTagBlockType([]string{"h3"})
+	cfg.CfgSetHeadingShift(1)
+	cfg.CfgSetLinkBaseURL("https://dighub.ru/blog/")
+	cfg.CfgSetLinkHostBlock([]string{"evil.com"})
+	cfg.CfgSetLinkHostInternal([]string{"dighub.ru"})
+	cfg.CfgSetImageProxy("https://camo.example.net", []byte("secret"), "sha1")
+	cfg.CfgSetLinkRewriteCallback(func(tag string, param string, link string, external bool) string {
+		if strings.Contains(link, "spam") {
+			return ""
+		}
+		if external && tag == "a" && !strings.HasPrefix(link, "https://dighub.ru/away?") {
+			return "https://dighub.ru/away?to=" + link
+		}
+		return link
+	})
+
+	link := func(href string, text string) qevix.DocNode {
+		return qevix.DocNode{Type: "text", Text: text, Marks: []qevix.DocMark{{Type: "a", Attrs: map[string]string{"href": href}}}}
+	}
+	forged := "https://camo.example.net/00/" + "687474703a2f2f6576696c2e636f6d2f692e706e67"
+
+	doc := &qevix.Document{Version: qevix.DocumentVersion, Content: []qevix.DocNode{
+		{Type: "h2", Content: []qevix.DocNode{{Type: "text", Text: "Заголовок"}}},
+		{Type: "p", Content: []qevix.DocNode{
+			link("http://evil.com/", "запрещенная"),
+			{Type: "text", Text: " "},
+			link("/post", "относительная"),
+			{Type: "text", Text: " "},
+			link("http://spam.example/", "отклоненная"),
+			{Type: "text", Text: " "},
+			link("http://example.com/", "внешняя"),
+		}},
+		{Type: "img", Attrs: map[string]string{"src": "http://example.com/i.png"}},
+		{Type: "img", Attrs: map[string]string{"src": forged}},
+	}}
+
+	text := `<h2>Заголовок</h2><p><a href="http://evil.com/">запрещенная</a> <a href="/post">относительная</a> ` +
+		`<a href="http://spam.example/">отклоненная</a> <a href="http://example.com/">внешняя</a></p>` +
+		`<img src="http://example.com/i.png"><img src="` + forged + `">`
+
+	expect, _ := cfg.Parse(text)
+
+	result, err := cfg.RenderDocument(doc)
+	if err != nil || result.Content != expect {
+		t.Errorf("Expect result to equal in func TestDocumentN5(t *testing.T).\n%s\n%s", expect, result.Content)
+	}
+
+	if strings.Contains(result.Content, "evil.com/\"") || strings.Contains(result.Content, "spam") || !strings.Contains(result.Content, "<h3") ||
+		!strings.Contains(result.Content, `href="https://dighub.ru/post"`) || strings.Contains(result.Content, `src="`+forged) {
+		t.Errorf("Expect policy to apply in func TestDocumentN5(t *testing.T).\n%s", result.Content)
+	}
+
+	// Повторная сборка собранного документа не меняет результат
+	parsed, _ := cfg.ParseDocument(text)
+	if again, _ := cfg.RenderDocument(parsed); again.Content != expect {
+		t.Errorf("Expect result to equal in func TestDocumentN5(t *testing.T).\n%s\n%s", expect, again.Content)
+	}
+}
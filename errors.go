@@ -0,0 +1,264 @@
+package qevix
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// Позиция во входном тексте
+//
+type Position struct {
+	Offset int // Смещение в байтах от начала текста
+	Rune   int // Смещение в рунах от начала текста
+	Line   int // Номер строки, начиная с 1
+	Column int // Номер символа в строке, начиная с 1
+}
+
+func (self Position) String() string {
+	return strconv.Itoa(self.Line) + ":" + strconv.Itoa(self.Column)
+}
+
+//
+// Причина удаления тега или атрибута
+//
+type Reason int
+
+const (
+	ReasonNotAllowed     Reason = iota + 1 // Тег или атрибут отсутствует в списке разрешённых
+	ReasonCutWithContent                   // Тег вырезается вместе с содержимым
+	ReasonGlobalOnly                       // Тег не может быть дочерним к другим тегам
+	ReasonNotChild                         // Тег не может находиться внутри родительского тега
+	ReasonChildOnly                        // Тег может находиться только внутри других тегов
+	ReasonRequiredParam                    // У тега отсутствует обязательный атрибут
+	ReasonInvalidValue                     // Недопустимое значение атрибута
+	ReasonEmpty                            // Пустой тег
+	ReasonEmptyValue                       // Пустое значение атрибута
+	ReasonComment                          // Комментарии удаляются из текста
+	ReasonReview                           // Значение атрибута задано правилами
+	ReasonNormalized                       // Значение атрибута приведено к допустимому виду
+	ReasonHostNotAllowed                   // Домен ссылки запрещен или отсутствует в списке разрешённых
+	ReasonExternal                         // Значение атрибута задано правилами для внешних ссылок
+	ReasonHeadingLevel                     // Уровень заголовка изменен правилами
+	ReasonRenamed                          // Тег или атрибут переименован правилами
+	ReasonCutMarker                        // Маркер ката повторяется или вложен в другой маркер
+)
+
+var reasonText = map[Reason]string{
+	ReasonNotAllowed:     "отсутствует в списке разрешённых",
+	ReasonCutWithContent: "вырезается вместе с содержимым",
+	ReasonGlobalOnly:     "не может быть дочерним к другим тегам",
+	ReasonNotChild:       "не может находиться внутри родительского тега",
+	ReasonChildOnly:      "может находиться только внутри других тегов",
+	ReasonRequiredParam:  "отсутствует обязательный атрибут",
+	ReasonInvalidValue:   "недопустимое значение",
+	ReasonEmpty:          "пустой тег",
+	ReasonEmptyValue:     "пустое значение",
+	ReasonComment:        "комментарии удаляются",
+	ReasonReview:         "значение задано правилами",
+	ReasonNormalized:     "значение приведено к допустимому виду",
+	ReasonHostNotAllowed: "домен ссылки не разрешен",
+	ReasonExternal:       "значение задано правилами для внешних ссылок",
+	ReasonHeadingLevel:   "уровень заголовка изменен правилами",
+	ReasonRenamed:        "переименован правилами",
+	ReasonCutMarker:      "маркер ката повторяется или вложен",
+}
+
+func (self Reason) String() string {
+	if text, ok := reasonText[self]; ok {
+		return text
+	}
+	return "неизвестная причина"
+}
+
+//
+// Закрывающий тег без открывающего
+//
+type UnexpectedCloseTagError struct {
+	Tag string
+	Pos Position
+}
+
+func (self *UnexpectedCloseTagError) Error() string {
+	return self.Pos.String() + ": Не ожидалось закрывающего тега '" + self.Tag + "'"
+}
+
+//
+// Закрывающий тег не соответствует открытому
+//
+type MismatchedCloseTagError struct {
+	Tag      string // Встреченный закрывающий тег
+	Expected string // Тег, закрытие которого ожидалось
+	Pos      Position
+}
+
+func (self *MismatchedCloseTagError) Error() string {
+	return self.Pos.String() + ": Неверный закрывающийся тег '" + self.Tag + "'. Ожидалось закрытие '" + self.Expected + "'"
+}
+
+//
+// Недопустимое значение атрибута
+//
+type InvalidParamValueError struct {
+	Tag   string
+	Param string
+	Value string
+	Pos   Position
+}
+
+func (self *InvalidParamValueError) Error() string {
+	return self.Pos.String() + ": Недопустимое значение '" + self.Value + "' для атрибута '" + self.Param + "' тега '" + self.Tag + "'"
+}
+
+//
+// Тег удален из текста
+//
+type DroppedTagError struct {
+	Tag    string
+	Reason Reason
+	Pos    Position
+}
+
+func (self *DroppedTagError) Error() string {
+	return self.Pos.String() + ": Тег '" + self.Tag + "' удален: " + self.Reason.String()
+}
+
+//
+// Атрибут удален из тега
+//
+type DroppedParamError struct {
+	Tag   string
+	Param string
+	Value string
+	Pos   Position
+}
+
+func (self *DroppedParamError) Error() string {
+	return self.Pos.String() + ": Атрибут '" + self.Param + "' тега '" + self.Tag + "' удален: " + ReasonNotAllowed.String()
+}
+
+//
+// Маркер ката повторяется или вложен в другой маркер
+//
+type CutMarkerError struct {
+	Tag    string
+	Nested bool // Маркер вложен в другой маркер, иначе повторяется
+	Pos    Position
+}
+
+func (self *CutMarkerError) Error() string {
+	if self.Nested {
+		return self.Pos.String() + ": Маркер ката '" + self.Tag + "' не может содержать другой маркер"
+	}
+	return self.Pos.String() + ": Маркер ката '" + self.Tag + "' может быть только один"
+}
+
+//
+// Вычисляет позицию во входном тексте по позиции в буфере рун
+//
+// Буфер рун не содержит символов "\r", поэтому позиция считается по исходному тексту.
+// Индекс позиций строится при первом вызове за время парсинга.
+//
+// pos int - позиция в буфере рун
+//
+func (self *parser) position(pos int) Position {
+	if len(self.posOffsets) == 0 {
+		self.buildPositions()
+	}
+
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > self.textLen {
+		pos = self.textLen
+	}
+
+	line := sort.SearchInts(self.lineStarts, pos+1) - 1
+
+	return Position{
+		Offset: self.posOffsets[pos],
+		Rune:   self.posRunes[pos],
+		Line:   line + 1,
+		Column: pos - self.lineStarts[line] + 1,
+	}
+}
+
+//
+// Строит индекс позиций буфера рун во входном тексте
+//
+func (self *parser) buildPositions() {
+	self.posOffsets = self.posOffsets[:0]
+	self.posRunes = self.posRunes[:0]
+	self.lineStarts = append(self.lineStarts[:0], 0)
+
+	runes := 0
+	for offset, char := range self.source {
+		if char == '\r' {
+			runes++
+			continue
+		}
+
+		self.posOffsets = append(self.posOffsets, offset)
+		self.posRunes = append(self.posRunes, runes)
+		runes++
+
+		if char == '\n' {
+			self.lineStarts = append(self.lineStarts, len(self.posOffsets))
+		}
+	}
+
+	self.posOffsets = append(self.posOffsets, len(self.source))
+	self.posRunes = append(self.posRunes, runes)
+}
+
+//
+// Ошибка конфигурации
+//
+type ConfigError struct {
+	Method string // Метод конфигурации
+	Tag    string // Тег, если ошибка относится к тегу
+	Param  string // Параметр, если ошибка относится к параметру
+	Msg    string // Описание ошибки
+}
+
+func (self *ConfigError) Error() string {
+	msg := "qevix: " + self.Method + ": "
+	if self.Tag != "" {
+		msg += "тег '" + self.Tag + "': "
+	}
+	if self.Param != "" {
+		msg += "параметр '" + self.Param + "': "
+	}
+	return msg + self.Msg
+}
+
+//
+// Список ошибок конфигурации
+//
+type ConfigErrors []error
+
+func (self ConfigErrors) Error() string {
+	msgs := make([]string, len(self))
+	for i, err := range self {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+//
+// Возвращает ошибки списка для errors.Is и errors.As
+//
+func (self ConfigErrors) Unwrap() []error {
+	return self
+}
+
+//
+// Возвращает nil для пустого списка ошибок, иначе список, даже если ошибка одна
+//
+func (self ConfigErrors) err() error {
+	if len(self) == 0 {
+		return nil
+	}
+	return self
+}
@@ -0,0 +1,98 @@
+package qevix_test
+
+import (
+	"errors"
+	"qevix"
+	"testing"
+)
+
+var errorsQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgAllowTags([]string{"b", "img", "ul", "li"})
+	cfg.CfgSetTagShort([]string{"img"})
+	cfg.CfgSetTagCutWithContent([]string{"iframe"})
+	cfg.CfgSetDropErrorsMode(true)
+	cfg.CfgAllowTagParams("img", []string{"src", "width"})
+	cfg.CfgAllowTagParamValue("img", "src", "#link")
+	cfg.CfgAllowTagParamValue("img", "width", "#regexp(^[0-9]+$)")
+	cfg.CfgSetTagChilds("ul", []string{"li"})
+	cfg.CfgSetTagParentOnly([]string{"ul"})
+})
+
+func TestErrorsN1(t *testing.T) {
+	_, errs := errorsQvx.Parse("текст\nтекст </b> текст")
+
+	var err *qevix.UnexpectedCloseTagError
+	if len(errs) != 1 || !errors.As(errs[0], &err) {
+		t.Fatalf("Expect UnexpectedCloseTagError in func TestErrorsN1(t *testing.T).\n%v", errs)
+	}
+
+	expect := qevix.Position{Offset: 22, Rune: 12, Line: 2, Column: 7}
+	if err.Tag != "b" || err.Pos != expect {
+		t.Errorf("Expect error to equal in func TestErrorsN1(t *testing.T).\n%+v", err)
+	}
+}
+
+func TestErrorsN2(t *testing.T) {
+	_, errs := errorsQvx.Parse("<b>текст</i>")
+
+	var err *qevix.MismatchedCloseTagError
+	if len(errs) != 1 || !errors.As(errs[0], &err) {
+		t.Fatalf("Expect MismatchedCloseTagError in func TestErrorsN2(t *testing.T).\n%v", errs)
+	}
+
+	if err.Tag != "i" || err.Expected != "b" || err.Pos.Column != 9 {
+		t.Errorf("Expect error to equal in func TestErrorsN2(t *testing.T).\n%+v", err)
+	}
+}
+
+func TestErrorsN3(t *testing.T) {
+	_, errs := errorsQvx.Parse("текст\r\n<img src=\"http://dighub.ru/a.png\" width=\"abc\" hreflang=\"ru\">")
+
+	var invalid *qevix.InvalidParamValueError
+	var dropped *qevix.DroppedParamError
+	for _, err := range errs {
+		errors.As(err, &invalid)
+		errors.As(err, &dropped)
+	}
+
+	if invalid == nil || invalid.Param != "width" || invalid.Value != "abc" {
+		t.Fatalf("Expect InvalidParamValueError in func TestErrorsN3(t *testing.T).\n%v", errs)
+	}
+
+	expect := qevix.Position{Offset: 46, Rune: 41, Line: 2, Column: 35}
+	if invalid.Pos != expect {
+		t.Errorf("Expect position to equal in func TestErrorsN3(t *testing.T).\n%+v", invalid.Pos)
+	}
+
+	if dropped == nil || dropped.Tag != "img" || dropped.Param != "hreflang" || dropped.Pos.Column != 47 {
+		t.Errorf("Expect DroppedParamError in func TestErrorsN3(t *testing.T).\n%v", errs)
+	}
+}
+
+func TestErrorsN4(t *testing.T) {
+	_, errs := errorsQvx.Parse("<s>текст</s> <iframe>текст</iframe>")
+
+	reasons := []qevix.Reason{}
+	for _, err := range errs {
+		var dropped *qevix.DroppedTagError
+		if errors.As(err, &dropped) {
+			reasons = append(reasons, dropped.Reason)
+		}
+	}
+
+	if len(reasons) != 2 || reasons[0] != qevix.ReasonNotAllowed || reasons[1] != qevix.ReasonCutWithContent {
+		t.Errorf("Expect DroppedTagError in func TestErrorsN4(t *testing.T).\n%v", errs)
+	}
+}
+
+func TestErrorsN5(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgApplySpec(errorsQvx.Spec())
+	cfg.CfgSetDropErrorsMode(false)
+
+	result := cfg.ParseResult(`<s>текст</s> <img src="http://dighub.ru/a.png" hreflang="ru"> <iframe>текст</iframe>`)
+
+	if len(result.Errors) != 0 || len(result.Report) != 3 {
+		t.Errorf("Expect only report entries in func TestErrorsN5(t *testing.T).\n%v\n%v", result.Errors, result.Report)
+	}
+}
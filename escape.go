@@ -0,0 +1,42 @@
+package qevix
+
+//
+// Исходная разметка тега, как она записана в тексте
+//
+type tagMarkup struct {
+	open  string // Открывающий тег с параметрами
+	close string // Закрывающий тег, пустая строка если его нет
+}
+
+//
+// Возвращает исходный текст между позициями
+//
+// from int - начальная позиция
+// to int - конечная позиция, не включается
+//
+func (self *parser) sourceText(from int, to int) string {
+	if from < 0 {
+		from = 0
+	}
+	if to < 0 || to > self.textLen {
+		to = self.textLen
+	}
+	if from >= to {
+		return ""
+	}
+	return string(self.textBuf[from:to])
+}
+
+//
+// Выводит запрещенный тег как текст: исходная разметка экранируется, содержимое остается обработанным
+//
+// tagSource tagMarkup - исходная разметка тега
+// tagContent []*Node - контент тега
+//
+func (self *parser) escapeTag(tagSource tagMarkup, tagContent []*Node) []*Node {
+	nodes := nodeList{}
+	nodes.addText(tagSource.open)
+	nodes.add(tagContent...)
+	nodes.addText(tagSource.close)
+	return nodes.result()
+}
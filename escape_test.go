@@ -0,0 +1,62 @@
+package qevix_test
+
+import (
+	"qevix"
+	"testing"
+)
+
+func TestEscapeN1(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"b", "ul", "li"})
+	cfg.CfgSetTagParentOnly([]string{"ul"})
+	cfg.CfgSetTagChildOnly([]string{"li"})
+	cfg.CfgSetTagChilds("ul", []string{"li"})
+	cfg.CfgSetTagCutWithContent([]string{"script"})
+	cfg.CfgSetEscapeMode(true)
+
+	texts := map[string]string{
+		`use the <section> element`:                         `use the &#60;section&#62; element`,
+		`<section class="x" data-id='1'><b>a</b></section>`: `&#60;section class=&#34;x&#34; data-id=&#39;1&#39;&#62;<b>a</b>&#60;/section&#62;`,
+		`a<script>alert(1)</script>b`:                       `ab`,
+		`<ul><li>a</li><section>b</section></ul>`:           "<ul>\n<li>a</li>\n</ul>",
+		`x <section> a </section> y`:                        `x &#60;section&#62; a &#60;/section&#62; y`,
+		`<b>a</b>`:                                          `<b>a</b>`,
+		"<section>\nx\n</section>":                          "&#60;section&#62;\nx<br>\n&#60;/section&#62;",
+	}
+
+	for text, expect := range texts {
+		result, _ := cfg.Parse(text)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestEscapeN1(t *testing.T).\n%s: %s", text, result)
+		}
+	}
+
+	result := cfg.ParseResult(`<section>a</section>`)
+	if len(result.Report) != 1 || result.Report[0].String() != `1:1: Тег <section> выведен как текст: отсутствует в списке разрешённых` {
+		t.Errorf("Expect report to equal in func TestEscapeN1(t *testing.T).\n%v", result.Report)
+	}
+}
+
+func TestEscapeN2(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"b"})
+	cfg.CfgSetTagEscape([]string{"section"})
+
+	texts := map[string]string{
+		`<section>a</section>`: `&#60;section&#62;a&#60;/section&#62;`,
+		`<article>a</article>`: `a`,
+	}
+
+	for text, expect := range texts {
+		result, _ := cfg.Parse(text)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestEscapeN2(t *testing.T).\n%s: %s", text, result)
+		}
+	}
+
+	if err := cfg.CfgSetTagEscape([]string{"<x>"}); err == nil {
+		t.Errorf("Expect error in func TestEscapeN2(t *testing.T).\n%s", "<x>")
+	}
+}
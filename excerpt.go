@@ -0,0 +1,226 @@
+package qevix
+
+import (
+	"strings"
+	"unicode"
+)
+
+//
+// Состояние обрезки текста
+//
+type excerpt struct {
+	limit  int             // Допустимое число символов или слов
+	words  bool            // Считать слова, а не символы
+	used   int             // Использовано символов или слов
+	inWord bool            // Предыдущий символ относится к слову
+	isCut  bool            // Текст обрезан
+	blocks map[string]bool // Блочные теги, разделяющие слова
+}
+
+//
+// Парсинг строки и обрезка результата после заданного числа видимых символов.
+// Теги не разрываются и закрываются, пустые теги в конце удаляются, к обрезанному тексту добавляется многоточие.
+//
+// Вызов безопасен из нескольких горутин
+//
+// text string - входная строка для парсинга
+// limit int - число видимых символов
+//
+func (self *Policy) Excerpt(text string, limit int) (string, []error) {
+	return self.excerpt(text, &excerpt{limit: limit, blocks: self.tagBlockType})
+}
+
+//
+// Парсинг строки и обрезка результата после заданного числа слов
+//
+// Вызов безопасен из нескольких горутин
+//
+// text string - входная строка для парсинга
+// limit int - число слов
+//
+func (self *Policy) ExcerptWords(text string, limit int) (string, []error) {
+	return self.excerpt(text, &excerpt{limit: limit, words: true, blocks: self.tagBlockType})
+}
+
+//
+// Обрезает дерево отфильтрованного текста и собирает HTML
+//
+// text string - входная строка для парсинга
+// state *excerpt - состояние обрезки
+//
+func (self *Policy) excerpt(text string, state *excerpt) (string, []error) {
+	nodes, errs := self.ParseTree(text)
+	if state.limit <= 0 {
+		return "", errs
+	}
+
+	nodes = state.nodes(nodes)
+	if !state.isCut {
+		return self.Render(nodes), errs
+	}
+
+	nodes = trimExcerpt(nodes)
+	if len(nodes) > 0 {
+		nodes = appendEllipsis(nodes, self.excerptEllipsis)
+	}
+
+	return strings.TrimSpace(self.Render(nodes)), errs
+}
+
+//
+// Копирует узлы, пока не исчерпан лимит
+//
+// nodes []*Node - узлы
+//
+func (self *excerpt) nodes(nodes []*Node) []*Node {
+	result := []*Node{}
+
+	for _, node := range nodes {
+		if self.isCut {
+			break
+		}
+
+		switch {
+		case node.Kind == NodeText:
+			if text := self.text(node.Text); text != "" {
+				result = append(result, &Node{Kind: NodeText, Text: text, autoLink: node.autoLink})
+			}
+		// Короткий тег после исчерпанного лимита не добавляется, перевод строки разделяет слова
+		case node.Short:
+			if self.used == self.limit {
+				self.isCut = true
+				break
+			}
+			if node.Tag == "br" {
+				self.inWord = false
+			}
+			result = append(result, node)
+		// Автоматическая ссылка и HTML callback-функции не разрываются: попадают целиком или не попадают совсем
+		case isAutoLinkNode(node) || node.Kind == NodeRaw:
+			state := *self
+			state.text(nodesText(node.Children))
+			if state.isCut {
+				self.isCut = true
+				break
+			}
+			*self = state
+			result = append(result, node)
+		// Границы блочного тега разделяют слова
+		default:
+			if self.blocks[node.Tag] {
+				self.inWord = false
+			}
+			clone := *node
+			clone.Children = self.nodes(node.Children)
+			result = append(result, &clone)
+			if self.blocks[node.Tag] {
+				self.inWord = false
+			}
+		}
+	}
+
+	return result
+}
+
+//
+// Возвращает часть текста, которая помещается в лимит
+//
+// text string - текст без экранирования
+//
+func (self *excerpt) text(text string) string {
+	for i, char := range text {
+		// Перевод строки не считается символом, но разделяет слова
+		if char == '\n' {
+			self.inWord = false
+			continue
+		}
+
+		if self.words {
+			isSpace := unicode.IsSpace(char)
+			if !isSpace && !self.inWord {
+				if self.used == self.limit {
+					self.isCut = true
+					return text[:i]
+				}
+				self.used++
+			}
+			self.inWord = !isSpace
+			continue
+		}
+
+		if self.used == self.limit {
+			self.isCut = true
+			return text[:i]
+		}
+		self.used++
+	}
+
+	return text
+}
+
+//
+// Ссылка, которая содержит только текст своего адреса, как при автоподсветке ссылок
+//
+// node *Node - узел
+//
+func isAutoLinkNode(node *Node) bool {
+	if node.Kind != NodeElement || node.Tag != "a" || len(node.Children) != 1 || node.Children[0].Kind != NodeText {
+		return false
+	}
+	href, _ := node.Attr("href")
+	return node.Children[0].Text != "" && strings.HasSuffix(href, node.Children[0].Text)
+}
+
+//
+// Удаляет в конце обрезанного текста пробелы, пустые теги и переводы строк
+//
+// nodes []*Node - узлы
+//
+func trimExcerpt(nodes []*Node) []*Node {
+	for len(nodes) > 0 {
+		last := nodes[len(nodes)-1]
+
+		switch {
+		case last.Kind == NodeText:
+			last.Text = strings.TrimRightFunc(last.Text, unicode.IsSpace)
+			if last.Text != "" {
+				return nodes
+			}
+		case last.Kind == NodeRaw:
+			return nodes
+		case last.Tag == "br":
+		case last.Short:
+			return nodes
+		default:
+			last.Children = trimExcerpt(last.Children)
+			if len(last.Children) > 0 {
+				return nodes
+			}
+		}
+
+		nodes = nodes[:len(nodes)-1]
+	}
+
+	return nodes
+}
+
+//
+// Добавляет многоточие к последнему тексту
+//
+// nodes []*Node - узлы
+// ellipsis string - многоточие
+//
+func appendEllipsis(nodes []*Node, ellipsis string) []*Node {
+	last := nodes[len(nodes)-1]
+
+	switch {
+	case last.Kind == NodeText:
+		last.Text += ellipsis
+	case last.Kind == NodeElement && !last.Short && last.Tag != "a" && len(last.Children) > 0:
+		last.Children = appendEllipsis(last.Children, ellipsis)
+	default:
+		nodes = append(nodes, &Node{Kind: NodeText, Text: ellipsis})
+	}
+
+	return nodes
+}
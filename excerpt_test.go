@@ -0,0 +1,69 @@
+package qevix_test
+
+import (
+	"qevix"
+	"testing"
+)
+
+var excerptQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgAllowTags([]string{"a", "b", "i", "p", "img"})
+	cfg.CfgSetTagShort([]string{"img"})
+	cfg.CfgSetTagBlockType([]string{"p"})
+	cfg.CfgAllowTagParams("a", []string{"href"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+	cfg.CfgAllowTagParams("img", []string{"src"})
+	cfg.CfgAllowTagParamValue("img", "src", "#link")
+})
+
+func TestExcerptN1(t *testing.T) {
+	tests := []struct {
+		text   string
+		limit  int
+		expect string
+	}{
+		{`<p>Привет, <b>мир</b>!</p>`, 100, `<p>Привет, <b>мир</b>!</p>`},
+		{`<p>Привет, <b>мир</b>!</p>`, 10, `<p>Привет, <b>ми…</b></p>`},
+		{`<p>Привет, <b>мир</b>!</p>`, 8, `<p>Привет,…</p>`},
+		{`<p>a &lt; b &amp; c</p>`, 3, `<p>a &#60;…</p>`},
+		{"<p>раз</p>\n<p>два</p>", 3, `<p>раз…</p>`},
+		{`<p>см. http://dighub.ru/page здесь</p>`, 10, `<p>см.…</p>`},
+		{`<p>ab<img src="http://dighub.ru/i.png">cd</p>`, 3, `<p>ab<img src="http://dighub.ru/i.png">c…</p>`},
+		{`<p>hello<img src="http://dighub.ru/i.png"> world</p>`, 5, `<p>hello…</p>`},
+		{"строка 1\nстрока 2", 10, "строка 1<br>\nст…"},
+		{`a`, 0, ``},
+	}
+
+	for _, test := range tests {
+		result, _ := excerptQvx.Excerpt(test.text, test.limit)
+
+		if result != test.expect {
+			t.Errorf("Expect result to equal in func TestExcerptN1(t *testing.T).\n%s: %s", test.text, result)
+		}
+	}
+}
+
+func TestExcerptN2(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgApplySpec(excerptQvx.Spec())
+	cfg.CfgSetExcerptEllipsis(" [...]")
+
+	tests := []struct {
+		text   string
+		limit  int
+		expect string
+	}{
+		{`<p>Один <b>два три</b> четыре</p>`, 3, `<p>Один <b>два три [...]</b></p>`},
+		{`<p>Один <b>два три</b> четыре</p>`, 2, `<p>Один <b>два [...]</b></p>`},
+		{`<p>Один <b>два три</b> четыре</p>`, 4, `<p>Один <b>два три</b> четыре</p>`},
+		{"one\ntwo\nthree", 1, `one [...]`},
+		{`<p>one</p><p>two</p><p>three</p>`, 1, `<p>one [...]</p>`},
+	}
+
+	for _, test := range tests {
+		result, _ := cfg.ExcerptWords(test.text, test.limit)
+
+		if result != test.expect {
+			t.Errorf("Expect result to equal in func TestExcerptN2(t *testing.T).\n%s: %s", test.text, result)
+		}
+	}
+}
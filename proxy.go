@@ -0,0 +1,83 @@
+package qevix
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"strings"
+)
+
+//
+// Алгоритмы подписи ссылок прокси изображений
+//
+var imageProxyDigests = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+//
+// Прокси изображений в формате Camo: <адрес прокси>/<hmac>/<hex ссылки>
+//
+type imageProxy struct {
+	base   string           // Адрес прокси без завершающего "/"
+	key    []byte           // Ключ HMAC
+	digest func() hash.Hash // Алгоритм подписи
+}
+
+//
+// Возвращает подписанную ссылку на изображение через прокси
+//
+// link string - абсолютная ссылка на изображение
+//
+func (self *imageProxy) sign(link string) string {
+	mac := hmac.New(self.digest, self.key)
+	mac.Write([]byte(link))
+
+	return self.base + "/" + hex.EncodeToString(mac.Sum(nil)) + "/" + hex.EncodeToString([]byte(link))
+}
+
+//
+// Проверяет, что ссылка уже подписана этим прокси
+//
+// link string - ссылка
+//
+func (self *imageProxy) signed(link string) bool {
+	if !strings.HasPrefix(link, self.base+"/") {
+		return false
+	}
+
+	parts := strings.Split(link[len(self.base)+1:], "/")
+	if len(parts) != 2 {
+		return false
+	}
+
+	target, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal([]byte(self.sign(string(target))), []byte(link))
+}
+
+//
+// Проверяет, нужно ли пропускать ссылку через прокси, и приводит ее к абсолютному виду.
+// Через прокси пропускаются только ссылки http и https.
+//
+// link string - ссылка
+//
+func proxyLink(link string) (string, bool) {
+	if strings.HasPrefix(link, "//") {
+		link = "https:" + link
+	}
+
+	lower := strings.ToLower(link)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return link, false
+	}
+
+	return link, true
+}
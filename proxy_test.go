@@ -0,0 +1,100 @@
+package qevix_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"qevix"
+	"testing"
+)
+
+var proxyQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgAllowTags([]string{"img", "video"})
+	cfg.CfgSetTagShort([]string{"img"})
+	cfg.CfgSetTagIsEmpty([]string{"video"})
+	cfg.CfgAllowTagParams("img", []string{"src"})
+	cfg.CfgAllowTagParamValue("img", "src", "#link")
+	cfg.CfgAllowTagParams("video", []string{"src", "poster"})
+	cfg.CfgAllowTagParamValue("video", "src", "#link")
+	cfg.CfgAllowTagParamValue("video", "poster", "#link")
+	cfg.CfgSetTagParamImage("video", []string{"poster"})
+	cfg.CfgSetLinkHostInternal([]string{"dighub.ru"})
+	cfg.CfgSetImageProxy("https://proxy.dighub.ru/", []byte("secret"), "sha1")
+})
+
+func camoURL(digest func() hash.Hash, key string, link string) string {
+	mac := hmac.New(digest, []byte(key))
+	mac.Write([]byte(link))
+	return "https://proxy.dighub.ru/" + hex.EncodeToString(mac.Sum(nil)) + "/" + hex.EncodeToString([]byte(link))
+}
+
+func TestProxyN1(t *testing.T) {
+	text := `<img src="http://github.com/a.png"><img src="//github.com/b.png"><img src="https://dighub.ru/c.png"><img src="/d.png">`
+
+	expect := `<img src="` + camoURL(sha1.New, "secret", "http://github.com/a.png") + `">` +
+		`<img src="` + camoURL(sha1.New, "secret", "https://github.com/b.png") + `">` +
+		`<img src="https://dighub.ru/c.png"><img src="/d.png">`
+
+	result, _ := proxyQvx.Parse(text)
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestProxyN1(t *testing.T).\n%s", result)
+	}
+}
+
+func TestProxyN2(t *testing.T) {
+	text := `<video src="http://github.com/a.mp4" poster="http://github.com/a.png"></video>`
+
+	expect := `<video src="http://github.com/a.mp4" poster="` + camoURL(sha1.New, "secret", "http://github.com/a.png") + `"></video>`
+
+	result, _ := proxyQvx.Parse(text)
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestProxyN2(t *testing.T).\n%s", result)
+	}
+}
+
+func TestProxyN3(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"img"})
+	cfg.CfgSetTagShort([]string{"img"})
+	cfg.CfgAllowTagParams("img", []string{"src"})
+	cfg.CfgAllowTagParamValue("img", "src", "#link")
+
+	if err := cfg.CfgSetImageProxy("https://proxy.dighub.ru", []byte("key"), "sha256"); err != nil {
+		t.Fatalf("Expect no error in func TestProxyN3(t *testing.T).\n%v", err)
+	}
+
+	result, _ := cfg.Compile().Parse(`<img src="https://github.com/a.png?x=1&y=2">`)
+
+	expect := `<img src="` + camoURL(sha256.New, "key", "https://github.com/a.png?x=1&y=2") + `">`
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestProxyN3(t *testing.T).\n%s", result)
+	}
+
+	if err := cfg.CfgSetImageProxy("https://proxy.dighub.ru", []byte("key"), "md5"); err == nil {
+		t.Errorf("Expect error in func TestProxyN3(t *testing.T).\n%s", "md5")
+	}
+
+	if err := cfg.CfgSetImageProxy("https://proxy.dighub.ru", nil, "sha1"); err == nil {
+		t.Errorf("Expect error in func TestProxyN3(t *testing.T).\n%s", "nil key")
+	}
+}
+
+func TestProxyN4(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgApplySpec(proxyQvx.Spec())
+	cfg.CfgSetImageProxy("https://proxy.dighub.ru/", []byte("secret"), "sha1")
+	cfg.CfgSetLinkBaseURL("https://github.com/user/")
+
+	result, _ := cfg.Parse(`<img src="/a.png"><img src="https://dighub.ru/b.png">`)
+
+	expect := `<img src="` + camoURL(sha1.New, "secret", "https://github.com/a.png") + `"><img src="https://dighub.ru/b.png">`
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestProxyN4(t *testing.T).\n%s", result)
+	}
+}
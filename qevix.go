@@ -13,10 +13,13 @@ package qevix
 
 import (
 	"bytes"
-	"errors"
 	"html"
+	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 const (
@@ -75,24 +78,30 @@ type state struct {
 }
 
 //
-// Парсер
-// qvx := qevix.New()
-// qvx.cnfTagAllow([]string{"a","b","i"})
-// str, err := qvx.Parse(text)
+// Правила фильтрации
 //
-type parser struct {
+type rules struct {
 	tagAllowed map[string]bool // Тег допустим
 
-	tagParamAllowed  map[string]map[string][]string // Параметр тега допустим
-	tagParamRequired map[string]map[string]bool     // Параметр тега является необходимым
+	tagParamAllowed  map[string]map[string][]string       // Параметр тега допустим
+	tagParamRules    map[string]map[string][]valueMatcher // Скомпилированные шаблоны значений параметров
+	tagParamRequired map[string]map[string]bool           // Параметр тега является необходимым
 
 	tagParamSorted map[string][]string // Отсортированый срез имен параметров для тегов
 
 	tagParamDefault map[string]map[string]string // Автодобавление параметров со значениями по умолчанию
 	tagParamReview  map[string]map[string]string // Параметры значения которых нужно заменить на указанные
 
+	tagParamHosts    map[string]map[string][]string // Домены, разрешенные в ссылках параметра тега
+	tagParamExternal map[string]map[string]string   // Параметры, заменяемые у тегов с внешними ссылками
+	tagParamImage    map[string]map[string]bool     // Параметры со ссылками на изображения
+
+	tagRename   map[string]tagRename         // Теги, переименовываемые до проверки правил
+	paramRename map[string]map[string]string // Параметры, переименовываемые до проверки правил
+
 	tagShort          map[string]bool // Тег короткий
 	tagCutWithContent map[string]bool // Тег необходимо вырезать вместе с его контентом
+	tagEscape         map[string]bool // Запрещенный тег выводится как текст, а не удаляется
 	tagGlobalOnly     map[string]bool // Тег может находиться только в "глобальной" области видимости (не быть дочерним к другим)
 	tagParentOnly     map[string]bool // Тег может содержать только другие теги
 	tagChildOnly      map[string]bool // Тег может находиться только внутри других тегов
@@ -115,6 +124,78 @@ type parser struct {
 	nl          string          // Символы перевода строки
 	br          string          // Тег <br>
 
+	linkProtocolAllow []string        // Разрешенные схемы для ссылок
+	linkProtocolSet   map[string]bool // Множество разрешенных схем
+	linkHostBlock     []string        // Запрещенные домены ссылок
+	linkHostInternal  []string        // Собственные домены, ссылки на них не считаются внешними
+	linkBase          string          // Базовый адрес для относительных ссылок
+	linkBaseURL       *url.URL        // Разобранный базовый адрес
+
+	linkRewriteCallback func(string, string, string, bool) string // Перезапись принятых ссылок
+	imageProxy          *imageProxy                               // Прокси для внешних изображений
+
+	linkProtocolValidators map[string]func(string) (string, bool) // Проверки ссылок для отдельных схем
+
+	paramValidators map[string]func(string) (string, bool) // Именованные шаблоны значений параметров
+
+	styleProperties map[string][]string       // Разрешенные свойства встроенных стилей и шаблоны их значений
+	styleRules      map[string][]valueMatcher // Скомпилированные шаблоны значений свойств стилей
+
+	classPrefix string // Префикс, добавляемый к классам, прошедшим шаблон #class
+	idPrefix    string // Префикс, добавляемый к параметрам id и name и к ссылкам-якорям
+
+	headingAnchors bool // Добавлять заголовкам h1-h6 якоря и собирать оглавление
+	headingShift   int  // На сколько уровней понижать заголовки
+	headingMin     int  // Минимальный уровень заголовков
+	headingMax     int  // Максимальный уровень заголовков
+
+	excerptEllipsis string // Многоточие, добавляемое к обрезанному тексту
+
+	cutTag string // Тег маркера ката, разделяющего анонс и полный текст
+
+	specialChars map[rune]func(string) string // Функции повешенные на специальные символы (@,#,$)
+
+	isXHTMLMode       bool // Включение режима XHTML
+	isAutoBrMode      bool // Включение авторасстановки тегов переноса строк
+	isAutoLinkMode    bool // Включение автоподсветки ссылок
+	isSpecialCharMode bool // Включение отлавливания строк предваренных специальными символами (@,#,$)
+	isTypoMode        bool // Влючение типографирования
+	isEscapeMode      bool // Включение вывода запрещенных тегов как текста
+	isDropErrorsMode  bool // Включение ошибок об удаленных правилами тегах и атрибутах
+}
+
+//
+// Конфигурация фильтра
+// qvx := qevix.New()
+// qvx.CfgAllowTags([]string{"a","b","i"})
+// policy := qvx.Compile()
+//
+// Методы политики, вызванные через конфигурацию, работают с правилами напрямую
+// и безопасны из нескольких горутин, только пока конфигурация не меняется.
+//
+type Config struct {
+	*Policy // Политика работающая напрямую с правилами конфигурации
+
+	errs ConfigErrors // Ошибки, возникшие при вызовах Cfg*
+}
+
+//
+// Скомпилированная политика фильтрации.
+// Не изменяется после создания и может использоваться из нескольких горутин одновременно.
+// str, err := policy.Parse(text)
+//
+type Policy struct {
+	*rules
+
+	pool sync.Pool // Пул состояний парсера
+}
+
+//
+// Парсер, состояние одного вызова Parse
+//
+type parser struct {
+	*Policy
+
 	textBuf []rune // Буфер с рунами
 	textLen int    // Длина буфера рун
 
@@ -130,27 +211,38 @@ type parser struct {
 	nextChar      rune // Следующий символ
 	nextCharClass int  // Следующий класс символа
 
-	curTag            string   // Текущий тег
-	statesStack       []state  // Стек состояний
-	quotesOpened      int      // Кол-во открытых кавычек
-	linkProtocolAllow []string // Разрешенные схемы для ссылок
+	curTag       string  // Текущий тег
+	statesStack  []state // Стек состояний
+	quotesOpened int     // Кол-во открытых кавычек
 
-	specialChars map[rune]func(string) string // Функции повешенные на специальные символы (@,#,$)
+	isTypoMode bool // Типографирование в текущем теге
+	discard    int  // Глубина вложенности в теги, которые будут удалены вместе с содержимым
+	inCut      int  // Глубина вложенности в маркеры ката
 
-	isXHTMLMode       bool // Включение режима XHTML
-	isAutoBrMode      bool // Включение авторасстановки тегов переноса строк
-	isAutoLinkMode    bool // Включение автоподсветки ссылок
-	isSpecialCharMode bool // Включение отлавливания строк предваренных специальными символами (@,#,$)
-	isTypoMode        bool // Влючение типографирования
+	source     string // Исходный текст для вычисления позиций
+	posOffsets []int  // Смещения рун буфера в байтах исходного текста
+	posRunes   []int  // Смещения рун буфера в рунах исходного текста
+	lineStarts []int  // Позиции начала строк в буфере рун
 
 	errorsList []error // Ошибки в разметке произошедшие за время парсинга
+	report     Report  // Отчет об изменениях произошедших за время парсинга
+
+	ids     map[string]bool // Идентификаторы id и name, выданные за время парсинга
+	idsNext map[string]int  // Следующий суффикс для повторов идентификатора
+	toc     TOC             // Оглавление, собранное за время парсинга
+
+	isAutoLink bool // Собирается автоматическая ссылка из текста
+
+	cut        *Position           // Позиция маркера ката, nil если маркер не найден
+	cutMarkers map[*Node]cutMarker // Маркеры ката, найденные за время парсинга
 }
 
-func New() *parser {
-	return &parser{
+func New() *Config {
+	r := &rules{
 		tagAllowed: make(map[string]bool),
 
 		tagParamAllowed:  make(map[string]map[string][]string),
+		tagParamRules:    make(map[string]map[string][]valueMatcher),
 		tagParamRequired: make(map[string]map[string]bool),
 
 		tagParamSorted: make(map[string][]string),
@@ -158,8 +250,18 @@ func New() *parser {
 		tagParamDefault: make(map[string]map[string]string),
 		tagParamReview:  make(map[string]map[string]string),
 
+		tagParamHosts:    make(map[string]map[string][]string),
+		tagParamExternal: make(map[string]map[string]string),
+		tagParamImage: map[string]map[string]bool{
+			"img": {"src": true},
+		},
+
+		tagRename:   make(map[string]tagRename),
+		paramRename: make(map[string]map[string]string),
+
 		tagShort:          make(map[string]bool),
 		tagCutWithContent: make(map[string]bool),
+		tagEscape:         make(map[string]bool),
 		tagGlobalOnly:     make(map[string]bool),
 		tagParentOnly:     make(map[string]bool),
 		tagChildOnly:      make(map[string]bool),
@@ -189,37 +291,249 @@ func New() *parser {
 		nl:   "\n",
 		br:   "<br>",
 
-		textBuf: []rune{},
-		textLen: 0,
+		linkProtocolAllow: []string{
+			"http", "https", "ftp",
+		},
+		linkProtocolValidators: make(map[string]func(string) (string, bool)),
 
-		prevPos:       -1,
-		prevChar:      -1,
-		prevCharClass: NULL,
+		paramValidators: make(map[string]func(string) (string, bool)),
+		specialChars:    make(map[rune]func(string) string),
 
-		curPos:       -1,
-		curChar:      -1,
-		curCharClass: NULL,
+		styleProperties: make(map[string][]string),
+		styleRules:      make(map[string][]valueMatcher),
 
-		nextPos:       -1,
-		nextChar:      -1,
-		nextCharClass: NULL,
+		headingMin: 1,
+		headingMax: 6,
 
-		curTag:       "",
-		statesStack:  []state{},
-		quotesOpened: 0,
-		linkProtocolAllow: []string{
-			"http", "https", "ftp",
-		},
-		specialChars: make(map[rune]func(string) string),
+		excerptEllipsis: "…",
 
 		isXHTMLMode:       false,
 		isAutoBrMode:      true,
 		isAutoLinkMode:    true,
 		isSpecialCharMode: false,
 		isTypoMode:        true,
+	}
+
+	r.linkProtocolSet = compileLinkProtocols(r.linkProtocolAllow)
+
+	return &Config{Policy: newPolicy(r)}
+}
+
+//
+// Создает политику поверх правил
+//
+// r *rules - правила фильтрации
+//
+func newPolicy(r *rules) *Policy {
+	policy := &Policy{rules: r}
+	policy.pool.New = func() interface{} {
+		return &parser{Policy: policy}
+	}
+	return policy
+}
+
+//
+// Компилирует конфигурацию в неизменяемую политику.
+// Последующие вызовы Cfg* не влияют на уже созданную политику.
+//
+func (self *Config) Compile() *Policy {
+	return newPolicy(self.rules.clone())
+}
+
+var (
+	tagNameRx       = regexp.MustCompile(`^[a-z0-9]+$`)
+	paramNameRx     = regexp.MustCompile(`^[a-z][a-z0-9_:\-]*$`)
+	protocolRx      = regexp.MustCompile(`^[a-z][a-z0-9+.\-]*$`)
+	paramWildcardRx = regexp.MustCompile(`^[a-z][a-z0-9_:\-]*-\*$`)
+	idRx            = regexp.MustCompile(`^[\pL_][\pL\pN_\-.:]{0,127}$`)
+	hostRx          = regexp.MustCompile(`^[\pL\pN]([\pL\pN\-.]*[\pL\pN])?$`)
+)
+
+//
+// Проверяет конфигурацию и возвращает все найденные проблемы:
+// ошибки вызовов Cfg* (в том числе неверные регулярные выражения в шаблонах #regexp(...)),
+// противоречивые флаги тегов, параметры, отсутствующие в списке разрешённых, дочерние теги без родителей
+// и именованные шаблоны, для которых не зарегистрирована функция CfgSetParamValidator
+//
+func (self *Config) Validate() error {
+	return self.validate(true)
+}
+
+//
+// Проверяет конфигурацию
+//
+// checkValidators bool - проверять ли регистрацию именованных шаблонов. При загрузке из файла
+// функции шаблонов еще не зарегистрированы, они добавляются вызовами Cfg* после загрузки.
+//
+func (self *Config) validate(checkValidators bool) error {
+	errs := append(ConfigErrors{}, self.errs...)
+
+	problem := func(tag, param, msg string) {
+		errs = append(errs, &ConfigError{Method: "Validate", Tag: tag, Param: param, Msg: msg})
+	}
+
+	flags := []struct {
+		name  string
+		flags map[string]bool
+	}{
+		{"короткий", self.tagShort},
+		{"преформатированный", self.tagPreformatted},
+		{"без типографирования", self.tagNoTypography},
+		{"может быть пустым", self.tagEmpty},
+		{"без авторасстановки <br>", self.tagNoAutoBr},
+		{"блочный", self.tagBlockType},
+		{"только контейнер", self.tagParentOnly},
+		{"только дочерний", self.tagChildOnly},
+		{"только глобальный", self.tagGlobalOnly},
+	}
+	for _, flag := range flags {
+		for _, tag := range sortedKeys(flag.flags) {
+			if _, ok := self.tagAllowed[tag]; !ok {
+				problem(tag, "", "тег отмечен как "+flag.name+", но отсутствует в списке разрешённых тегов")
+			}
+		}
+	}
+
+	contradictions := []struct {
+		a, b   map[string]bool
+		aName  string
+		bName  string
+		reason string
+	}{
+		{self.tagShort, self.tagParentOnly, "короткий", "только контейнер", "короткий тег не может содержать другие теги"},
+		{self.tagShort, self.tagPreformatted, "короткий", "преформатированный", "короткий тег не имеет содержимого"},
+		{self.tagShort, self.tagEmpty, "короткий", "может быть пустым", "флаг пустого тега применим только к не коротким тегам"},
+		{self.tagChildOnly, self.tagGlobalOnly, "только дочерний", "только глобальный", "тег не сможет находиться ни в одном месте текста"},
+		{self.tagAllowed, self.tagCutWithContent, "разрешённый", "вырезаемый вместе с содержимым", "тег всегда будет вырезан"},
+	}
+	for _, c := range contradictions {
+		for _, tag := range sortedKeys(c.a) {
+			if c.b[tag] {
+				problem(tag, "", "тег одновременно "+c.aName+" и "+c.bName+": "+c.reason)
+			}
+		}
+	}
+
+	for _, tag := range sortedKeys(self.tagAllowed) {
+		for _, param := range sortedKeys(self.tagParamRequired[tag]) {
+			if _, _, ok := self.paramRule(tag, param); !ok {
+				problem(tag, param, "обязательный параметр отсутствует в списке разрешённых параметров")
+			}
+		}
+
+		for _, params := range []map[string]string{self.tagParamDefault[tag], self.tagParamReview[tag], self.tagParamExternal[tag]} {
+			names := []string{}
+			for param := range params {
+				names = append(names, param)
+			}
+			sort.Strings(names)
+
+			for _, param := range names {
+				if _, _, ok := self.paramRule(tag, param); !ok {
+					problem(tag, param, "параметр со значением по умолчанию или заменой отсутствует в списке разрешённых параметров")
+				}
+			}
+		}
+
+		if self.tagChildOnly[tag] && len(self.tagParent[tag]) == 0 {
+			problem(tag, "", "тег может быть только дочерним, но не указан дочерним ни для одного тега")
+		}
+
+		if self.tagParentOnly[tag] && len(self.tagChild[tag]) == 0 {
+			problem(tag, "", "тег может быть только контейнером, но для него не указаны дочерние теги")
+		}
+	}
+
+	if checkValidators {
+		for _, tag := range append(sortedKeys(self.tagAllowed), "*") {
+			for _, param := range self.tagParamSorted[tag] {
+				for _, template := range self.tagParamAllowed[tag][param] {
+					if _, ok := self.paramValidators[template]; !ok && !builtinTemplates[template] && validatorNameRx.MatchString(template) {
+						problem(tag, param, "шаблон "+template+" не зарегистрирован через CfgSetParamValidator, значение будет сравниваться с ним как с точным значением")
+					}
+				}
+			}
+		}
+	}
+
+	if len(self.styleProperties) == 0 {
+		for _, tag := range append(sortedKeys(self.tagAllowed), "*") {
+			for _, param := range self.tagParamSorted[tag] {
+				if IndexStringSlice(self.tagParamAllowed[tag][param], "#style") != -1 {
+					problem(tag, param, "параметр проверяется шаблоном #style, но не разрешено ни одно свойство стилей")
+				}
+			}
+		}
+	}
+
+	return errs.err()
+}
 
-		errorsList: []error{},
+//
+// Проверяет, что тег присутствует в списке разрешённых, и запоминает ошибку
+//
+// method string - метод конфигурации
+// tag string - тег
+//
+func (self *Config) checkTag(method string, tag string) error {
+	if _, ok := self.tagAllowed[tag]; !ok {
+		return self.setError(&ConfigError{Method: method, Tag: tag, Msg: "тег отсутствует в списке разрешённых тегов"})
 	}
+	return nil
+}
+
+//
+// Проверяет, что параметры задаются для разрешённого тега или для всех тегов ("*")
+//
+// method string - метод конфигурации
+// tag string - тег
+//
+func (self *Config) checkParamTag(method string, tag string) error {
+	if tag == "*" {
+		return nil
+	}
+	return self.checkTag(method, tag)
+}
+
+//
+// Устанавливает флаг для разрешённых тегов
+//
+// method string - метод конфигурации
+// flags map[string]bool - карта флагов
+// tags []string - теги
+//
+func (self *Config) setTagFlag(method string, flags map[string]bool, tags []string) error {
+	errs := ConfigErrors{}
+	for _, tag := range tags {
+		if err := self.checkTag(method, tag); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		flags[tag] = true
+	}
+	return errs.err()
+}
+
+//
+// Запоминает ошибку конфигурации
+//
+// err error - ошибка
+//
+func (self *Config) setError(err error) error {
+	self.errs = append(self.errs, err)
+	return err
+}
+
+//
+// Парсинг строки
+//
+// Вызов безопасен из нескольких горутин
+//
+// text string - входная строка для парсинга
+//
+func (self *Policy) Parse(text string) (string, []error) {
+	result := self.ParseResult(text)
+	return result.Content, result.Errors
 }
 
 //
@@ -227,8 +541,20 @@ func New() *parser {
 //
 // text string - входная строка для парсинга
 //
-func (self *parser) Parse(text string) (string, []error) {
-	// Обнуляем параметры
+func (self *parser) parse(text string) (Result, []*Node) {
+	self.reset(text)
+
+	self.movePos(0)
+
+	return self.result(self.makeContent(""))
+}
+
+//
+// Обнуляет состояние парсера перед парсингом
+//
+// text string - входная строка для парсинга
+//
+func (self *parser) reset(text string) {
 	self.prevPos = -1
 	self.prevChar = 0
 	self.prevCharClass = NULL
@@ -243,27 +569,257 @@ func (self *parser) Parse(text string) (string, []error) {
 
 	self.curTag = ""
 
-	self.statesStack = []state{}
+	self.statesStack = self.statesStack[:0]
 
 	self.quotesOpened = 0
 
+	self.isTypoMode = self.Policy.isTypoMode
+	self.discard = 0
+	self.inCut = 0
+
+	self.source = text
+	self.posOffsets = self.posOffsets[:0]
+
+	for id := range self.ids {
+		delete(self.ids, id)
+	}
+	for id := range self.idsNext {
+		delete(self.idsNext, id)
+	}
+
 	text = strings.Replace(text, "\r", "", -1)
 
-	self.textBuf = []rune(text)
+	self.textBuf = self.textBuf[:0]
+	for _, r := range text {
+		self.textBuf = append(self.textBuf, r)
+	}
 	self.textLen = len(self.textBuf)
 
 	self.errorsList = []error{}
+	self.report = Report{}
+	self.toc = nil
+	self.isAutoLink = false
+	self.cut = nil
+	for node := range self.cutMarkers {
+		delete(self.cutMarkers, node)
+	}
+}
 
-	self.movePos(0)
+//
+// Завершает парсинг и возвращает результат и дерево отфильтрованного текста,
+// состояние парсера очищается, чтобы парсер в пуле не удерживал дерево.
+//
+// nodes []*Node - собранные узлы
+//
+func (self *parser) result(nodes []*Node) (Result, []*Node) {
+	if len(self.cutMarkers) > 0 {
+		nodes = self.resolveCut(nodes)
+	}
+	nodes = trimNodes(nodes)
 
-	content := ""
-	content = self.makeContent("")
-	content = strings.Replace(content, "\n", self.nl, -1)
-	content = strings.TrimSpace(content)
+	teaser := ""
+	if self.cut != nil {
+		teaser, nodes = self.splitCut(nodes)
+	}
+
+	// Вложенные теги обрабатываются раньше родительских, упорядочиваем отчет по тексту
+	sort.SliceStable(self.report, func(i, j int) bool {
+		return self.report[i].Pos.Rune < self.report[j].Pos.Rune
+	})
 
-	errors := self.errorsList
+	result := Result{
+		Content: self.Render(nodes),
+		Errors:  self.errorsList,
+		Report:  self.report,
+		TOC:     self.toc,
+		Teaser:  teaser,
+		Cut:     self.cut,
+	}
 
-	return content, errors
+	self.errorsList = nil
+	self.report = nil
+	self.toc = nil
+	self.source = ""
+	for node := range self.cutMarkers {
+		delete(self.cutMarkers, node)
+	}
+
+	return result, nodes
+}
+
+//
+// Ищет правило для параметра тега: точное имя у тега, шаблон имени у тега (data-*),
+// затем точное имя и шаблон имени у всех тегов ("*").
+// Возвращает тег и имя параметра, под которыми правило хранится.
+//
+// tag string - тег
+// param string - параметр
+//
+func (self *rules) paramRule(tag string, param string) (string, string, bool) {
+	for _, ruleTag := range []string{tag, "*"} {
+		if _, ok := self.tagParamAllowed[ruleTag][param]; ok {
+			return ruleTag, param, true
+		}
+		for _, name := range self.tagParamSorted[ruleTag] {
+			if strings.HasSuffix(name, "*") && strings.HasPrefix(param, name[:len(name)-1]) {
+				return ruleTag, name, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+//
+// Порядок вывода параметров тега: параметры тега, затем параметры всех тегов в порядке объявления,
+// затем параметры по шаблонам имен в алфавитном порядке
+//
+// tag string - тег
+// params map[string]string - принятые параметры
+//
+func (self *rules) paramOrder(tag string, params map[string]string) []string {
+	order := make([]string, 0, len(params))
+	seen := make(map[string]bool, len(params))
+
+	for _, ruleTag := range []string{tag, "*"} {
+		for _, param := range self.tagParamSorted[ruleTag] {
+			if _, ok := params[param]; ok && !seen[param] {
+				order = append(order, param)
+				seen[param] = true
+			}
+		}
+	}
+
+	if len(order) == len(params) {
+		return order
+	}
+
+	rest := []string{}
+	for param := range params {
+		if !seen[param] {
+			rest = append(rest, param)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(order, rest...)
+}
+
+//
+// Создает глубокую копию правил
+//
+func (self *rules) clone() *rules {
+	r := *self
+
+	r.tagAllowed = cloneBoolMap(self.tagAllowed)
+
+	r.tagParamAllowed = make(map[string]map[string][]string, len(self.tagParamAllowed))
+	for tag, params := range self.tagParamAllowed {
+		r.tagParamAllowed[tag] = make(map[string][]string, len(params))
+		for param, values := range params {
+			r.tagParamAllowed[tag][param] = append([]string(nil), values...)
+		}
+	}
+	r.tagParamRules = make(map[string]map[string][]valueMatcher, len(self.tagParamRules))
+	for tag, params := range self.tagParamRules {
+		r.tagParamRules[tag] = make(map[string][]valueMatcher, len(params))
+		for param, matchers := range params {
+			r.tagParamRules[tag][param] = append([]valueMatcher(nil), matchers...)
+		}
+	}
+	r.tagParamRequired = cloneBoolMapMap(self.tagParamRequired)
+
+	r.tagParamSorted = make(map[string][]string, len(self.tagParamSorted))
+	for tag, params := range self.tagParamSorted {
+		r.tagParamSorted[tag] = append([]string(nil), params...)
+	}
+
+	r.tagParamDefault = cloneStringMapMap(self.tagParamDefault)
+	r.tagParamReview = cloneStringMapMap(self.tagParamReview)
+
+	r.tagParamHosts = make(map[string]map[string][]string, len(self.tagParamHosts))
+	for tag, params := range self.tagParamHosts {
+		r.tagParamHosts[tag] = make(map[string][]string, len(params))
+		for param, hosts := range params {
+			r.tagParamHosts[tag][param] = append([]string(nil), hosts...)
+		}
+	}
+	r.tagParamExternal = cloneStringMapMap(self.tagParamExternal)
+	r.tagParamImage = cloneBoolMapMap(self.tagParamImage)
+
+	r.tagRename = make(map[string]tagRename, len(self.tagRename))
+	for tag, rename := range self.tagRename {
+		r.tagRename[tag] = tagRename{tag: rename.tag, params: cloneStringMap(rename.params)}
+	}
+	r.paramRename = cloneStringMapMap(self.paramRename)
+
+	r.tagShort = cloneBoolMap(self.tagShort)
+	r.tagCutWithContent = cloneBoolMap(self.tagCutWithContent)
+	r.tagEscape = cloneBoolMap(self.tagEscape)
+	r.tagGlobalOnly = cloneBoolMap(self.tagGlobalOnly)
+	r.tagParentOnly = cloneBoolMap(self.tagParentOnly)
+	r.tagChildOnly = cloneBoolMap(self.tagChildOnly)
+
+	r.tagParent = cloneBoolMapMap(self.tagParent)
+	r.tagChild = cloneBoolMapMap(self.tagChild)
+
+	r.tagPreformatted = cloneBoolMap(self.tagPreformatted)
+	r.tagNoTypography = cloneBoolMap(self.tagNoTypography)
+	r.tagEmpty = cloneBoolMap(self.tagEmpty)
+	r.tagNoAutoBr = cloneBoolMap(self.tagNoAutoBr)
+	r.tagBlockType = cloneBoolMap(self.tagBlockType)
+
+	r.tagBuildCallback = make(map[string]func(string, map[string]string, string) string, len(self.tagBuildCallback))
+	for tag, cb := range self.tagBuildCallback {
+		r.tagBuildCallback[tag] = cb
+	}
+
+	r.entities = make(map[rune]string, len(self.entities))
+	for char, entity := range self.entities {
+		r.entities[char] = entity
+	}
+
+	r.quotes = make([][]rune, len(self.quotes))
+	for i, quote := range self.quotes {
+		r.quotes[i] = append([]rune(nil), quote...)
+	}
+
+	r.bracketsALL = make(map[rune]rune, len(self.bracketsALL))
+	for open, close := range self.bracketsALL {
+		r.bracketsALL[open] = close
+	}
+
+	r.linkProtocolAllow = append([]string(nil), self.linkProtocolAllow...)
+	r.linkProtocolSet = cloneBoolMap(self.linkProtocolSet)
+	r.linkHostBlock = append([]string(nil), self.linkHostBlock...)
+	r.linkHostInternal = append([]string(nil), self.linkHostInternal...)
+	if self.linkBaseURL != nil {
+		base := *self.linkBaseURL
+		r.linkBaseURL = &base
+	}
+
+	r.linkProtocolValidators = make(map[string]func(string) (string, bool), len(self.linkProtocolValidators))
+	for protocol, validator := range self.linkProtocolValidators {
+		r.linkProtocolValidators[protocol] = validator
+	}
+
+	r.styleProperties = make(map[string][]string, len(self.styleProperties))
+	r.styleRules = make(map[string][]valueMatcher, len(self.styleRules))
+	for property, values := range self.styleProperties {
+		r.styleProperties[property] = append([]string(nil), values...)
+		r.styleRules[property] = append([]valueMatcher(nil), self.styleRules[property]...)
+	}
+
+	r.paramValidators = make(map[string]func(string) (string, bool), len(self.paramValidators))
+	for name, validator := range self.paramValidators {
+		r.paramValidators[name] = validator
+	}
+
+	r.specialChars = make(map[rune]func(string) string, len(self.specialChars))
+	for char, cb := range self.specialChars {
+		r.specialChars[char] = cb
+	}
+
+	return &r
 }
 
 //
@@ -271,10 +827,16 @@ func (self *parser) Parse(text string) (string, []error) {
 //
 // tags []string - теги
 //
-func (self *parser) CfgAllowTags(tags []string) {
+func (self *Config) CfgAllowTags(tags []string) error {
+	errs := ConfigErrors{}
 	for _, tag := range tags {
+		if !tagNameRx.MatchString(tag) {
+			errs = append(errs, self.setError(&ConfigError{Method: "CfgAllowTags", Tag: tag, Msg: "недопустимое имя тега"}))
+			continue
+		}
 		self.tagAllowed[tag] = true
 	}
+	return errs.err()
 }
 
 //
@@ -282,13 +844,8 @@ func (self *parser) CfgAllowTags(tags []string) {
 //
 // tags []string - теги
 //
-func (self *parser) CfgSetTagShort(tags []string) {
-	for _, tag := range tags {
-		if _, ok := self.tagAllowed[tag]; !ok {
-			panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
-		}
-		self.tagShort[tag] = true
-	}
+func (self *Config) CfgSetTagShort(tags []string) error {
+	return self.setTagFlag("CfgSetTagShort", self.tagShort, tags)
 }
 
 //
@@ -296,13 +853,8 @@ func (self *parser) CfgSetTagShort(tags []string) {
 //
 // tags []string - теги
 //
-func (self *parser) CfgSetTagPreformatted(tags []string) {
-	for _, tag := range tags {
-		if _, ok := self.tagAllowed[tag]; !ok {
-			panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
-		}
-		self.tagPreformatted[tag] = true
-	}
+func (self *Config) CfgSetTagPreformatted(tags []string) error {
+	return self.setTagFlag("CfgSetTagPreformatted", self.tagPreformatted, tags)
 }
 
 //
@@ -310,13 +862,8 @@ func (self *parser) CfgSetTagPreformatted(tags []string) {
 //
 // tags []string - теги
 //
-func (self *parser) CfgSetTagNoTypography(tags []string) {
-	for _, tag := range tags {
-		if _, ok := self.tagAllowed[tag]; !ok {
-			panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
-		}
-		self.tagNoTypography[tag] = true
-	}
+func (self *Config) CfgSetTagNoTypography(tags []string) error {
+	return self.setTagFlag("CfgSetTagNoTypography", self.tagNoTypography, tags)
 }
 
 //
@@ -324,13 +871,8 @@ func (self *parser) CfgSetTagNoTypography(tags []string) {
 //
 // tags []string - теги
 //
-func (self *parser) CfgSetTagIsEmpty(tags []string) {
-	for _, tag := range tags {
-		if _, ok := self.tagAllowed[tag]; !ok {
-			panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
-		}
-		self.tagEmpty[tag] = true
-	}
+func (self *Config) CfgSetTagIsEmpty(tags []string) error {
+	return self.setTagFlag("CfgSetTagIsEmpty", self.tagEmpty, tags)
 }
 
 //
@@ -338,13 +880,8 @@ func (self *parser) CfgSetTagIsEmpty(tags []string) {
 //
 // tags []string - теги
 //
-func (self *parser) CfgSetTagNoAutoBr(tags []string) {
-	for _, tag := range tags {
-		if _, ok := self.tagAllowed[tag]; !ok {
-			panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
-		}
-		self.tagNoAutoBr[tag] = true
-	}
+func (self *Config) CfgSetTagNoAutoBr(tags []string) error {
+	return self.setTagFlag("CfgSetTagNoAutoBr", self.tagNoAutoBr, tags)
 }
 
 //
@@ -352,24 +889,87 @@ func (self *parser) CfgSetTagNoAutoBr(tags []string) {
 //
 // tags []string - теги
 //
-func (self *parser) CfgSetTagCutWithContent(tags []string) {
+func (self *Config) CfgSetTagCutWithContent(tags []string) error {
+	errs := ConfigErrors{}
 	for _, tag := range tags {
+		if !tagNameRx.MatchString(tag) {
+			errs = append(errs, self.setError(&ConfigError{Method: "CfgSetTagCutWithContent", Tag: tag, Msg: "недопустимое имя тега"}))
+			continue
+		}
 		self.tagCutWithContent[tag] = true
 	}
+	return errs.err()
 }
 
 //
-// КОНФИГУРАЦИЯ: Указывает теги после которых не нужно добавлять дополнительный перевод строки, например, блочные теги
+// КОНФИГУРАЦИЯ: Переименовывает тег до проверки правил (b → strong, center → div с class="align-center").
+// Добавляемые параметры заменяют одноименные параметры текста и проверяются правилами нового тега,
+// поэтому должны быть разрешены у него.
+//
+// from string - исходный тег
+// to string - новый тег
+// params map[string]string - добавляемые параметры, может быть nil
+//
+func (self *Config) CfgSetTagRename(from string, to string, params map[string]string) error {
+	if !tagNameRx.MatchString(from) || !tagNameRx.MatchString(to) {
+		return self.setError(&ConfigError{Method: "CfgSetTagRename", Tag: from, Msg: "недопустимое имя тега '" + from + "' или '" + to + "'"})
+	}
+	for param := range params {
+		if !paramNameRx.MatchString(param) {
+			return self.setError(&ConfigError{Method: "CfgSetTagRename", Tag: from, Param: param, Msg: "недопустимое имя параметра"})
+		}
+	}
+	self.tagRename[from] = tagRename{tag: to, params: cloneStringMap(params)}
+	return nil
+}
+
+//
+// КОНФИГУРАЦИЯ: Переименовывает параметр тега до проверки правил (font color → data-color).
+// Тег указывается исходный, до переименования, "*" - для всех тегов.
+//
+// tag string - исходный тег или "*"
+// from string - исходный параметр
+// to string - новый параметр
+//
+func (self *Config) CfgSetParamRename(tag string, from string, to string) error {
+	if tag != "*" && !tagNameRx.MatchString(tag) {
+		return self.setError(&ConfigError{Method: "CfgSetParamRename", Tag: tag, Msg: "недопустимое имя тега"})
+	}
+	if !paramNameRx.MatchString(from) || !paramNameRx.MatchString(to) {
+		return self.setError(&ConfigError{Method: "CfgSetParamRename", Tag: tag, Param: from, Msg: "недопустимое имя параметра '" + from + "' или '" + to + "'"})
+	}
+	if _, ok := self.paramRename[tag]; !ok {
+		self.paramRename[tag] = make(map[string]string)
+	}
+	self.paramRename[tag][from] = to
+	return nil
+}
+
+//
+// КОНФИГУРАЦИЯ: Указывает запрещенные теги, которые нужно выводить как текст (&#60;section&#62;), а не удалять.
+// Теги, вырезаемые вместе с содержимым, вырезаются и в этом случае.
 //
 // tags []string - теги
 //
-func (self *parser) CfgSetTagBlockType(tags []string) {
+func (self *Config) CfgSetTagEscape(tags []string) error {
+	errs := ConfigErrors{}
 	for _, tag := range tags {
-		if _, ok := self.tagAllowed[tag]; !ok {
-			panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
+		if !tagNameRx.MatchString(tag) {
+			errs = append(errs, self.setError(&ConfigError{Method: "CfgSetTagEscape", Tag: tag, Msg: "недопустимое имя тега"}))
+			continue
 		}
-		self.tagBlockType[tag] = true
+		self.tagEscape[tag] = true
 	}
+	return errs.err()
+}
+
+//
+// КОНФИГУРАЦИЯ: Указывает теги после которых не нужно добавлять дополнительный перевод строки, например, блочные теги
+//
+// tags []string - теги
+//
+func (self *Config) CfgSetTagBlockType(tags []string) error {
+	return self.setTagFlag("CfgSetTagBlockType", self.tagBlockType, tags)
 }
 
 //
@@ -378,157 +978,463 @@ func (self *parser) CfgSetTagBlockType(tags []string) {
 // tag string - тег
 // params []string - разрешённые параметры
 //
-func (self *parser) CfgAllowTagParams(tag string, params []string) {
-	if _, ok := self.tagAllowed[tag]; !ok {
-		panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
+func (self *Config) CfgAllowTagParams(tag string, params []string) error {
+	if err := self.checkParamTag("CfgAllowTagParams", tag); err != nil {
+		return err
 	}
+	errs := ConfigErrors{}
 	self.tagParamAllowed[tag] = make(map[string][]string)
+	self.tagParamRules[tag] = make(map[string][]valueMatcher)
 	self.tagParamSorted[tag] = []string{}
 	for _, param := range params {
+		if strings.Contains(param, "*") && !paramWildcardRx.MatchString(param) {
+			errs = append(errs, self.setError(&ConfigError{Method: "CfgAllowTagParams", Tag: tag, Param: param, Msg: "шаблон имени параметра должен иметь вид 'префикс-*'"}))
+			continue
+		}
 		self.tagParamAllowed[tag][param] = []string{"#str"}
+		self.tagParamRules[tag][param] = []valueMatcher{matchStrValue}
 		self.tagParamSorted[tag] = append(self.tagParamSorted[tag], param)
 	}
+	return errs.err()
+}
+
+//
+// КОНФИГУРАЦИЯ: Добавляет обязательные параметры для тега
+//
+// tag string - тег
+// params []string - обязательные параметры
+//
+func (self *Config) CfgSetTagParamsRequired(tag string, params []string) error {
+	if err := self.checkTag("CfgSetTagParamsRequired", tag); err != nil {
+		return err
+	}
+	self.tagParamRequired[tag] = make(map[string]bool)
+	for _, param := range params {
+		self.tagParamRequired[tag][param] = true
+	}
+	return nil
+}
+
+//
+// КОНФИГУРАЦИЯ: Уточняет значения параметра тега
+//
+// tag string - тег
+// param string - параметр
+// value interface{} - значение параметра, может быть строка или срез строк, разрешены шаблоны #str, #int, #float, #percent, #length, #link, #link(...), #regexp(...)
+// и шаблоны, зарегистрированные через CfgSetParamValidator
+//
+func (self *Config) CfgAllowTagParamValue(tag string, param string, value interface{}) error {
+	if err := self.checkParamTag("CfgAllowTagParamValue", tag); err != nil {
+		return err
+	}
+	if _, ok := self.tagParamAllowed[tag][param]; !ok {
+		return self.setError(&ConfigError{Method: "CfgAllowTagParamValue", Tag: tag, Param: param, Msg: "параметр отсутствует в списке разрешённых параметров"})
+	}
+	var val []string
+	switch v := value.(type) {
+	case string:
+		val = []string{v}
+	case []string:
+		val = v
+	default:
+		return self.setError(&ConfigError{Method: "CfgAllowTagParamValue", Tag: tag, Param: param, Msg: "значение должно быть строкой или срезом строк"})
+	}
+	errs := ConfigErrors{}
+	matchers := []valueMatcher{}
+	for _, template := range val {
+		matcher, err := compileParamValue(template)
+		if err != nil {
+			errs = append(errs, self.setError(&ConfigError{Method: "CfgAllowTagParamValue", Tag: tag, Param: param, Msg: "неверный шаблон '" + template + "': " + err.Error()}))
+			continue
+		}
+		matchers = append(matchers, matcher)
+	}
+	self.tagParamAllowed[tag][param] = val
+	self.tagParamRules[tag][param] = matchers
+	return errs.err()
+}
+
+//
+// КОНФИГУРАЦИЯ: Устанавливает префикс, который добавляется к каждому классу, прошедшему шаблон #class.
+// Не позволяет тексту пользователя использовать классы стилей сайта. Пустая строка отключает префикс.
+//
+// prefix string - префикс
+//
+func (self *Config) CfgSetClassPrefix(prefix string) error {
+	if prefix != "" && !classTokenRx.MatchString(prefix) {
+		return self.setError(&ConfigError{Method: "CfgSetClassPrefix", Msg: "недопустимый префикс классов '" + prefix + "'"})
+	}
+	self.classPrefix = prefix
+	return nil
+}
+
+//
+// КОНФИГУРАЦИЯ: Включает защиту от DOM clobbering. Значения параметров id и name проверяются как безопасные
+// идентификаторы, получают префикс и становятся уникальными в пределах одного парсинга (повтор получает суффикс -1, -2...,
+// id и name проверяются на повторы вместе).
+// Ссылки-якоря (#name) в параметрах с шаблоном #link получают тот же префикс. Пустая строка отключает защиту.
+//
+// prefix string - префикс, например "user-content-"
+//
+func (self *Config) CfgSetIDPrefix(prefix string) error {
+	if prefix != "" && !idRx.MatchString(prefix) {
+		return self.setError(&ConfigError{Method: "CfgSetIDPrefix", Msg: "недопустимый префикс идентификаторов '" + prefix + "'"})
+	}
+	self.idPrefix = prefix
+	return nil
+}
+
+//
+// КОНФИГУРАЦИЯ: Включает якоря заголовков. Заголовки h1-h6 без параметра id получают id из текста заголовка
+// (кириллица транслитерируется, повторы получают суффикс -1, -2...), а Result.TOC содержит оглавление текста.
+// Префикс из CfgSetIDPrefix добавляется и к созданным якорям.
+//
+// isEnabled bool - флаг
+//
+func (self *Config) CfgSetHeadingAnchors(isEnabled bool) {
+	self.headingAnchors = isEnabled
+}
+
+//
+// КОНФИГУРАЦИЯ: Понижает заголовки на заданное число уровней (при 2: h1 → h3), уровень ограничивается h6.
+// Заголовок переименовывается до проверки правил, поэтому разрешен должен быть тег с новым уровнем.
+//
+// shift int - число уровней, от 0 до 5
+//
+func (self *Config) CfgSetHeadingShift(shift int) error {
+	if shift < 0 || shift > 5 {
+		return self.setError(&ConfigError{Method: "CfgSetHeadingShift", Msg: "сдвиг заголовков должен быть от 0 до 5, задан " + strconv.Itoa(shift)})
+	}
+	self.headingShift = shift
+	return nil
+}
+
+//
+// КОНФИГУРАЦИЯ: Ограничивает уровни заголовков диапазоном (при 3, 4: h1 → h3, h6 → h4).
+// Применяется после сдвига из CfgSetHeadingShift.
+//
+// min int - минимальный уровень
+// max int - максимальный уровень
+//
+func (self *Config) CfgSetHeadingRange(min int, max int) error {
+	if min < 1 || max > 6 || min > max {
+		return self.setError(&ConfigError{Method: "CfgSetHeadingRange", Msg: "недопустимый диапазон заголовков " + strconv.Itoa(min) + "-" + strconv.Itoa(max)})
+	}
+	self.headingMin = min
+	self.headingMax = max
+	return nil
+}
+
+//
+// КОНФИГУРАЦИЯ: Задает многоточие, которое Excerpt и ExcerptWords добавляют к обрезанному тексту
+//
+// ellipsis string - многоточие, по умолчанию "…"
+//
+func (self *Config) CfgSetExcerptEllipsis(ellipsis string) {
+	self.excerptEllipsis = ellipsis
+}
+
+//
+// КОНФИГУРАЦИЯ: Задает тег маркера ката. Маркер не выводится в текст: Result.Teaser содержит текст до маркера
+// с закрытыми тегами, Result.Content - полный текст без маркера, Result.Cut - позицию маркера.
+// Повторный или вложенный маркер удаляется с ошибкой CutMarkerError. Пустая строка отключает обработку маркера.
+//
+// tag string - тег, например "cut"
+//
+func (self *Config) CfgSetCutTag(tag string) error {
+	if tag != "" && !tagNameRx.MatchString(tag) {
+		return self.setError(&ConfigError{Method: "CfgSetCutTag", Tag: tag, Msg: "недопустимое имя тега"})
+	}
+	self.cutTag = tag
+	return nil
+}
+
+//
+// КОНФИГУРАЦИЯ: Разрешает свойство встроенных стилей для параметров с шаблоном #style
+//
+// property string - свойство CSS
+// value interface{} - значение свойства, может быть строка или срез строк, разрешены те же шаблоны, что и для параметров
+//
+func (self *Config) CfgAllowStyleProperty(property string, value interface{}) error {
+	if !cssPropertyRx.MatchString(property) {
+		return self.setError(&ConfigError{Method: "CfgAllowStyleProperty", Param: property, Msg: "недопустимое имя свойства"})
+	}
+	var val []string
+	switch v := value.(type) {
+	case string:
+		val = []string{v}
+	case []string:
+		val = v
+	default:
+		return self.setError(&ConfigError{Method: "CfgAllowStyleProperty", Param: property, Msg: "значение должно быть строкой или срезом строк"})
+	}
+	errs := ConfigErrors{}
+	matchers := []valueMatcher{}
+	for _, template := range val {
+		if template == "#style" {
+			errs = append(errs, self.setError(&ConfigError{Method: "CfgAllowStyleProperty", Param: property, Msg: "шаблон #style нельзя использовать для свойства"}))
+			continue
+		}
+		matcher, err := compileParamValue(template)
+		if err != nil {
+			errs = append(errs, self.setError(&ConfigError{Method: "CfgAllowStyleProperty", Param: property, Msg: "неверный шаблон '" + template + "': " + err.Error()}))
+			continue
+		}
+		matchers = append(matchers, matcher)
+	}
+	self.styleProperties[property] = val
+	self.styleRules[property] = matchers
+	return errs.err()
+}
+
+//
+// КОНФИГУРАЦИЯ: Указывает, какие теги являются контейнерами для других тегов
+//
+// tag string - тег
+// childs []string - разрешённые дочерние теги
+//
+func (self *Config) CfgSetTagChilds(tag string, childs []string) error {
+	if err := self.checkTag("CfgSetTagChilds", tag); err != nil {
+		return err
+	}
+	errs := ConfigErrors{}
+	self.tagChild[tag] = make(map[string]bool)
+	for _, child := range childs {
+		if err := self.checkTag("CfgSetTagChilds", child); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, ok := self.tagParent[child]; !ok {
+			self.tagParent[child] = make(map[string]bool)
+		}
+		self.tagChild[tag][child] = true
+		self.tagParent[child][tag] = true
+	}
+	return errs.err()
+}
+
+//
+// КОНФИГУРАЦИЯ: Указывает, какие теги могут быть только контейнерами для других тегов
+//
+// tags []string - теги являются только контейнером для других тегов и не могут содержать текст
+//
+func (self *Config) CfgSetTagParentOnly(tags []string) error {
+	return self.setTagFlag("CfgSetTagParentOnly", self.tagParentOnly, tags)
+}
+
+//
+// КОНФИГУРАЦИЯ: Указывает, какие теги могут быть только дочерними для других тегов
+//
+// tags []string - теги являются только дочерними для других тегов
+//
+func (self *Config) CfgSetTagChildOnly(tags []string) error {
+	return self.setTagFlag("CfgSetTagChildOnly", self.tagChildOnly, tags)
+}
+
+//
+// КОНФИГУРАЦИЯ: Указывает, какие теги не должны быть дочерними к другим тегам
+//
+// tags []string - теги
+//
+func (self *Config) CfgSetTagGlobal(tags []string) error {
+	return self.setTagFlag("CfgSetTagGlobal", self.tagGlobalOnly, tags)
+}
+
+//
+// КОНФИГУРАЦИЯ: Указывает значения по умолчанию для параметров тега если они не заданы
+//
+// tag string - тег
+// param string - параметр
+// value string - значение
+//
+func (self *Config) CfgSetTagParamDefault(tag string, param string, value string) error {
+	if err := self.checkTag("CfgSetTagParamDefault", tag); err != nil {
+		return err
+	}
+	if _, ok := self.tagParamDefault[tag]; !ok {
+		self.tagParamDefault[tag] = make(map[string]string)
+	}
+	self.tagParamDefault[tag][param] = value
+	return nil
+}
+
+//
+// КОНФИГУРАЦИЯ: Указывает параметры значение которых нужно заменять на указанные значения
+//
+// tag string - тег
+// param string - параметр
+// value string - значение
+//
+func (self *Config) CfgSetTagParamReview(tag string, param string, value string) error {
+	if err := self.checkTag("CfgSetTagParamReview", tag); err != nil {
+		return err
+	}
+	if _, ok := self.tagParamReview[tag]; !ok {
+		self.tagParamReview[tag] = make(map[string]string)
+	}
+	self.tagParamReview[tag][param] = value
+	return nil
 }
 
 //
-// КОНФИГУРАЦИЯ: Добавляет обязательные параметры для тега
+// КОНФИГУРАЦИЯ: Указывает параметры значение которых нужно заменять у тегов со ссылкой на внешний домен.
+// Внешней считается ссылка с доменом, отсутствующим в списке CfgSetLinkHostInternal.
 //
 // tag string - тег
-// params []string - обязательные параметры
+// param string - параметр
+// value string - значение
 //
-func (self *parser) CfgSetTagParamsRequired(tag string, params []string) {
-	if _, ok := self.tagAllowed[tag]; !ok {
-		panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
+func (self *Config) CfgSetTagParamExternal(tag string, param string, value string) error {
+	if err := self.checkTag("CfgSetTagParamExternal", tag); err != nil {
+		return err
 	}
-	self.tagParamRequired[tag] = make(map[string]bool)
-	for _, param := range params {
-		self.tagParamRequired[tag][param] = true
+	if _, ok := self.tagParamExternal[tag]; !ok {
+		self.tagParamExternal[tag] = make(map[string]string)
 	}
+	self.tagParamExternal[tag][param] = value
+	return nil
 }
 
 //
-// КОНФИГУРАЦИЯ: Уточняет значения параметра тега
+// КОНФИГУРАЦИЯ: Ограничивает домены в ссылках параметра тега, проверенного шаблоном #link.
+// Домен разрешает и все свои поддомены. Относительные ссылки допускаются.
 //
 // tag string - тег
 // param string - параметр
-// value interface{} - значение параметра, может быть строка или срез строк, разрешены шаблоны #str, #int, #link, #regexp(...)
+// hosts []string - разрешенные домены
 //
-func (self *parser) CfgAllowTagParamValue(tag string, param string, value interface{}) {
-	if _, ok := self.tagAllowed[tag]; !ok {
-		panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
+func (self *Config) CfgSetTagParamHostAllow(tag string, param string, hosts []string) error {
+	if err := self.checkTag("CfgSetTagParamHostAllow", tag); err != nil {
+		return err
 	}
 	if _, ok := self.tagParamAllowed[tag][param]; !ok {
-		panic("Параметр '" + param + "' тега '" + tag + "' отсутствует в списке разрешённых параметров")
+		return self.setError(&ConfigError{Method: "CfgSetTagParamHostAllow", Tag: tag, Param: param, Msg: "параметр отсутствует в списке разрешённых параметров"})
 	}
-	var val []string
-	switch v := value.(type) {
-	case string:
-		val = []string{v}
-	case []string:
-		val = v
-	default:
-		panic("CfgAllowTagParamValue: тег " + tag + ", параметр " + param + ", значение должно быть строкой или срезом строк")
+	allow, err := self.normalizeHosts("CfgSetTagParamHostAllow", hosts)
+	if _, ok := self.tagParamHosts[tag]; !ok {
+		self.tagParamHosts[tag] = make(map[string][]string)
 	}
-	self.tagParamAllowed[tag][param] = val
+	self.tagParamHosts[tag][param] = allow
+	return err
 }
 
 //
-// КОНФИГУРАЦИЯ: Указывает, какие теги являются контейнерами для других тегов
+// КОНФИГУРАЦИЯ: Устанавливает базовый адрес, относительно которого разрешаются относительные ссылки.
+// Якоря (#name) не изменяются. Пустая строка отключает разрешение.
 //
-// tag string - тег
-// childs []string - разрешённые дочерние теги
+// base string - абсолютный адрес http или https
 //
-func (self *parser) CfgSetTagChilds(tag string, childs []string) {
-	if _, ok := self.tagAllowed[tag]; !ok {
-		panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
+func (self *Config) CfgSetLinkBaseURL(base string) error {
+	if base == "" {
+		self.linkBase, self.linkBaseURL = "", nil
+		return nil
 	}
-	self.tagChild[tag] = make(map[string]bool)
-	for _, child := range childs {
-		if _, ok := self.tagAllowed[child]; !ok {
-			panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
-		}
-		if _, ok := self.tagParent[child]; !ok {
-			self.tagParent[child] = make(map[string]bool)
-		}
-		self.tagChild[tag][child] = true
-		self.tagParent[child][tag] = true
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return self.setError(&ConfigError{Method: "CfgSetLinkBaseURL", Msg: "базовый адрес должен быть абсолютным адресом http или https: '" + base + "'"})
 	}
+	self.linkBase, self.linkBaseURL = base, u
+	return nil
 }
 
 //
-// КОНФИГУРАЦИЯ: Указывает, какие теги могут быть только контейнерами для других тегов
+// КОНФИГУРАЦИЯ: Устанавливает callback-функцию, которая вызывается для каждой принятой ссылки,
+// в том числе для ссылок автоподсветки. Функция получает тег, параметр, ссылку и признак внешней ссылки
+// и возвращает новую ссылку. Пустая строка означает, что ссылка отклонена.
+// Уже перезаписанную ссылку функция должна возвращать без изменений: ссылки документа проходят через нее повторно.
 //
-// tags []string - теги являются только контейнером для других тегов и не могут содержать текст
+// callback func(string, string, string, bool) string - функция
 //
-func (self *parser) CfgSetTagParentOnly(tags []string) {
-	for _, tag := range tags {
-		if _, ok := self.tagAllowed[tag]; !ok {
-			panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
-		}
-		self.tagParentOnly[tag] = true
-	}
+func (self *Config) CfgSetLinkRewriteCallback(callback func(string, string, string, bool) string) {
+	self.linkRewriteCallback = callback
 }
 
 //
-// КОНФИГУРАЦИЯ: Указывает, какие теги могут быть только дочерними для других тегов
+// КОНФИГУРАЦИЯ: Включает прокси изображений в формате Camo.
+// Внешние ссылки на изображения заменяются на <proxy>/<hmac>/<hex ссылки>. Пустой адрес прокси отключает замену.
 //
-// tags []string - теги являются только дочерними для других тегов
+// proxy string - адрес прокси http или https
+// key []byte - ключ HMAC
+// digest string - алгоритм подписи: sha1, sha256 или sha512
 //
-func (self *parser) CfgSetTagChildOnly(tags []string) {
-	for _, tag := range tags {
-		if _, ok := self.tagAllowed[tag]; !ok {
-			panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
-		}
-		self.tagChildOnly[tag] = true
+func (self *Config) CfgSetImageProxy(proxy string, key []byte, digest string) error {
+	if proxy == "" {
+		self.imageProxy = nil
+		return nil
 	}
+	u, err := url.Parse(proxy)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return self.setError(&ConfigError{Method: "CfgSetImageProxy", Msg: "адрес прокси должен быть абсолютным адресом http или https: '" + proxy + "'"})
+	}
+	if len(key) == 0 {
+		return self.setError(&ConfigError{Method: "CfgSetImageProxy", Msg: "не задан ключ подписи"})
+	}
+	hash, ok := imageProxyDigests[digest]
+	if !ok {
+		return self.setError(&ConfigError{Method: "CfgSetImageProxy", Msg: "неизвестный алгоритм подписи '" + digest + "'"})
+	}
+	self.imageProxy = &imageProxy{base: strings.TrimSuffix(proxy, "/"), key: append([]byte(nil), key...), digest: hash}
+	return nil
 }
 
 //
-// КОНФИГУРАЦИЯ: Указывает, какие теги не должны быть дочерними к другим тегам
+// КОНФИГУРАЦИЯ: Указывает параметры тега, содержащие ссылки на изображения (по умолчанию src у img).
+// Такие ссылки пропускаются через прокси изображений.
 //
-// tags []string - теги
+// tag string - тег
+// params []string - параметры
 //
-func (self *parser) CfgSetTagGlobal(tags []string) {
-	for _, tag := range tags {
-		if _, ok := self.tagAllowed[tag]; !ok {
-			panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
-		}
-		self.tagGlobalOnly[tag] = true
+func (self *Config) CfgSetTagParamImage(tag string, params []string) error {
+	if err := self.checkTag("CfgSetTagParamImage", tag); err != nil {
+		return err
 	}
+	self.tagParamImage[tag] = make(map[string]bool)
+	for _, param := range params {
+		self.tagParamImage[tag][param] = true
+	}
+	return nil
 }
 
 //
-// КОНФИГУРАЦИЯ: Указывает значения по умолчанию для параметров тега если они не заданы
+// КОНФИГУРАЦИЯ: Устанавливает список запрещенных доменов для ссылок. Домен запрещает и все свои поддомены.
 //
-// tag string - тег
-// param string - параметр
-// value string - значение
+// hosts []string - запрещенные домены
 //
-func (self *parser) CfgSetTagParamDefault(tag string, param string, value string) {
-	if _, ok := self.tagAllowed[tag]; !ok {
-		panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
-	}
-	if _, ok := self.tagParamDefault[tag]; !ok {
-		self.tagParamDefault[tag] = make(map[string]string)
-	}
-	self.tagParamDefault[tag][param] = value
+func (self *Config) CfgSetLinkHostBlock(hosts []string) error {
+	block, err := self.normalizeHosts("CfgSetLinkHostBlock", hosts)
+	self.linkHostBlock = block
+	return err
 }
 
 //
-// КОНФИГУРАЦИЯ: Указывает параметры значение которых нужно заменять на указанные значения
+// КОНФИГУРАЦИЯ: Устанавливает список собственных доменов. Ссылки на них и их поддомены не считаются внешними.
 //
-// tag string - тег
-// param string - параметр
-// value string - значение
+// hosts []string - собственные домены
 //
-func (self *parser) CfgSetTagParamReview(tag string, param string, value string) {
-	if _, ok := self.tagAllowed[tag]; !ok {
-		panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
-	}
-	if _, ok := self.tagParamReview[tag]; !ok {
-		self.tagParamReview[tag] = make(map[string]string)
+func (self *Config) CfgSetLinkHostInternal(hosts []string) error {
+	internal, err := self.normalizeHosts("CfgSetLinkHostInternal", hosts)
+	self.linkHostInternal = internal
+	return err
+}
+
+//
+// Приводит список доменов к нижнему регистру и проверяет их
+//
+// method string - метод конфигурации
+// hosts []string - домены
+//
+func (self *Config) normalizeHosts(method string, hosts []string) ([]string, error) {
+	errs := ConfigErrors{}
+	result := []string{}
+	for _, host := range hosts {
+		normalized := strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(host)), "*."), ".")
+		if !hostRx.MatchString(normalized) {
+			errs = append(errs, self.setError(&ConfigError{Method: method, Msg: "недопустимое имя домена '" + host + "'"}))
+			continue
+		}
+		result = append(result, normalized)
 	}
-	self.tagParamReview[tag][param] = value
+	return result, errs.err()
 }
 
 //
@@ -537,11 +1443,12 @@ func (self *parser) CfgSetTagParamReview(tag string, param string, value string)
 // tag string - тег
 // callback func(string, map[string]string, string) string - функция
 //
-func (self *parser) CfgSetTagBuildCallback(tag string, callback func(string, map[string]string, string) string) {
-	if _, ok := self.tagAllowed[tag]; !ok {
-		panic("Тег '" + tag + "' отсутствует в списке разрешённых тегов")
+func (self *Config) CfgSetTagBuildCallback(tag string, callback func(string, map[string]string, string) string) error {
+	if err := self.checkTag("CfgSetTagBuildCallback", tag); err != nil {
+		return err
 	}
 	self.tagBuildCallback[tag] = callback
+	return nil
 }
 
 //
@@ -550,12 +1457,35 @@ func (self *parser) CfgSetTagBuildCallback(tag string, callback func(string, map
 // char rune - спецсимвол
 // callback func(string)string - функция
 //
-func (self *parser) CfgSetSpecialCharCallback(char rune, callback func(string) string) {
-	if (self.getClassByOrd(char) & SPECIAL_CHAR) == NULL {
-		panic("Значение параметр char метода CfgSetSpecialCharCallback отсутствует в списке разрешенных символов")
+func (self *Config) CfgSetSpecialCharCallback(char rune, callback func(string) string) error {
+	if (getClassByOrd(char) & SPECIAL_CHAR) == NULL {
+		return self.setError(&ConfigError{Method: "CfgSetSpecialCharCallback", Msg: "символ '" + string(char) + "' отсутствует в списке разрешенных символов"})
 	}
 	self.isSpecialCharMode = true
 	self.specialChars[char] = callback
+	return nil
+}
+
+//
+// КОНФИГУРАЦИЯ: Регистрирует именованный шаблон значений параметров (#color, #lang).
+// Функция получает значение параметра и возвращает значение, возможно измененное, и признак допустимости.
+// Шаблон проверяется при каждом парсинге, поэтому его можно зарегистрировать до или после CfgAllowTagParamValue.
+//
+// name string - имя шаблона, начинается с "#"
+// validator func(string) (string, bool) - функция
+//
+func (self *Config) CfgSetParamValidator(name string, validator func(string) (string, bool)) error {
+	if !validatorNameRx.MatchString(name) {
+		return self.setError(&ConfigError{Method: "CfgSetParamValidator", Msg: "недопустимое имя шаблона '" + name + "'"})
+	}
+	if builtinTemplates[name] {
+		return self.setError(&ConfigError{Method: "CfgSetParamValidator", Msg: "шаблон '" + name + "' является встроенным"})
+	}
+	if validator == nil {
+		return self.setError(&ConfigError{Method: "CfgSetParamValidator", Msg: "не задана функция шаблона '" + name + "'"})
+	}
+	self.paramValidators[name] = validator
+	return nil
 }
 
 //
@@ -563,14 +1493,44 @@ func (self *parser) CfgSetSpecialCharCallback(char rune, callback func(string) s
 //
 // protocols []string - список протоколов
 //
-func (self *parser) CfgSetLinkProtocolAllow(protocols []string) {
-	self.linkProtocolAllow = protocols
+func (self *Config) CfgSetLinkProtocolAllow(protocols []string) error {
+	errs := ConfigErrors{}
+	allow := []string{}
+	for _, protocol := range protocols {
+		if !protocolRx.MatchString(protocol) {
+			errs = append(errs, self.setError(&ConfigError{Method: "CfgSetLinkProtocolAllow", Msg: "недопустимое имя протокола '" + protocol + "'"}))
+			continue
+		}
+		allow = append(allow, protocol)
+	}
+	self.linkProtocolAllow = allow
+	self.linkProtocolSet = compileLinkProtocols(allow)
+	return errs.err()
+}
+
+//
+// КОНФИГУРАЦИЯ: Устанавливает проверку ссылок с указанной схемой (mailto, tel, myapp).
+// Функция получает ссылку целиком и возвращает ссылку, возможно измененную, и признак допустимости.
+// Заменяет встроенную проверку схем mailto, tel и xmpp.
+//
+// protocol string - схема
+// validator func(string) (string, bool) - функция
+//
+func (self *Config) CfgSetLinkProtocolValidator(protocol string, validator func(string) (string, bool)) error {
+	if !protocolRx.MatchString(protocol) {
+		return self.setError(&ConfigError{Method: "CfgSetLinkProtocolValidator", Msg: "недопустимое имя протокола '" + protocol + "'"})
+	}
+	if validator == nil {
+		return self.setError(&ConfigError{Method: "CfgSetLinkProtocolValidator", Msg: "не задана функция для протокола '" + protocol + "'"})
+	}
+	self.linkProtocolValidators[protocol] = validator
+	return nil
 }
 
 //
 // КОНФИГУРАЦИЯ: Включает или выключает режим XHTML
 //
-func (self *parser) CfgSetXHTMLMode(isXHTMLMode bool) {
+func (self *Config) CfgSetXHTMLMode(isXHTMLMode bool) {
 	if isXHTMLMode {
 		self.br = "<br/>"
 	} else {
@@ -582,26 +1542,43 @@ func (self *parser) CfgSetXHTMLMode(isXHTMLMode bool) {
 //
 // КОНФИГУРАЦИЯ: Включает или выключает режим автозамены символов переводов строк на тег <br>
 //
-func (self *parser) CfgSetAutoBrMode(isAutoBrMode bool) {
+func (self *Config) CfgSetAutoBrMode(isAutoBrMode bool) {
 	self.isAutoBrMode = isAutoBrMode
 }
 
 //
 // КОНФИГУРАЦИЯ: Включает или выключает режим автоматического определения ссылок
 //
-func (self *parser) CfgSetAutoLinkMode(isAutoLinkMode bool) {
+func (self *Config) CfgSetAutoLinkMode(isAutoLinkMode bool) {
 	self.isAutoLinkMode = isAutoLinkMode
 }
 
+//
+// КОНФИГУРАЦИЯ: Включает или выключает режим вывода всех запрещенных тегов как текста вместо их удаления
+//
+func (self *Config) CfgSetEscapeMode(isEscapeMode bool) {
+	self.isEscapeMode = isEscapeMode
+}
+
+//
+// КОНФИГУРАЦИЯ: Включает или выключает ошибки DroppedTagError и DroppedParamError об удаленных правилами тегах и атрибутах.
+// По умолчанию удаления попадают только в отчет, а Parse возвращает ошибки разметки.
+//
+func (self *Config) CfgSetDropErrorsMode(isDropErrorsMode bool) {
+	self.isDropErrorsMode = isDropErrorsMode
+}
+
 //
 // КОНФИГУРАЦИЯ: Задает символ/символы перевода строки "\n" или "\r\n"
 //
 // nl string - "\n" или "\r\n"
 //
-func (self *parser) CfgSetEOL(nl string) {
-	if nl == "\n" || nl == "\r\n" {
-		self.nl = nl
+func (self *Config) CfgSetEOL(nl string) error {
+	if nl != "\n" && nl != "\r\n" {
+		return self.setError(&ConfigError{Method: "CfgSetEOL", Msg: "допустимы только \"\\n\" и \"\\r\\n\""})
 	}
+	self.nl = nl
+	return nil
 }
 
 //
@@ -609,7 +1586,7 @@ func (self *parser) CfgSetEOL(nl string) {
 //
 // ord rune - код символа
 //
-func (self *parser) getClassByOrd(ord rune) int {
+func getClassByOrd(ord rune) int {
 	if _, ok := CHAR_CLASSES[ord]; ok {
 		return CHAR_CLASSES[ord]
 	}
@@ -644,7 +1621,7 @@ func (self *parser) movePos(position int) bool {
 
 	if prevPos < self.textLen && prevPos >= 0 {
 		self.prevChar = self.textBuf[prevPos]
-		self.prevCharClass = self.getClassByOrd(self.prevChar)
+		self.prevCharClass = getClassByOrd(self.prevChar)
 	} else {
 		self.prevChar = 0
 		self.prevCharClass = NULL
@@ -654,7 +1631,7 @@ func (self *parser) movePos(position int) bool {
 
 	if curPos < self.textLen && curPos >= 0 {
 		self.curChar = self.textBuf[curPos]
-		self.curCharClass = self.getClassByOrd(self.curChar)
+		self.curCharClass = getClassByOrd(self.curChar)
 	} else {
 		self.curChar = 0
 		self.curCharClass = NULL
@@ -664,7 +1641,7 @@ func (self *parser) movePos(position int) bool {
 
 	if nextPos < self.textLen && nextPos >= 0 {
 		self.nextChar = self.textBuf[nextPos]
-		self.nextCharClass = self.getClassByOrd(self.nextChar)
+		self.nextCharClass = getClassByOrd(self.nextChar)
 	} else {
 		self.nextChar = 0
 		self.nextCharClass = NULL
@@ -929,12 +1906,12 @@ func (self *parser) grabNotCharClass(class int) string {
 }
 
 //
-// Готовит контент, возвращает строку с готовым текстом
+// Готовит контент, возвращает узлы с готовым текстом
 //
 // parentTag string - имя родительского тега или пустая строка
 //
-func (self *parser) makeContent(parentTag string) string {
-	content := bytes.NewBufferString("")
+func (self *parser) makeContent(parentTag string) []*Node {
+	content := nodeList{}
 
 	self.skipSpaces()
 	self.skipNL(-1)
@@ -942,7 +1919,9 @@ func (self *parser) makeContent(parentTag string) string {
 	for self.curCharClass != NULL {
 		tagName := ""
 		tagParams := make(map[string]string)
-		tagContent := ""
+		tagParamsPos := make(map[string]int)
+		tagContent := []*Node{}
+		tagSource := tagMarkup{}
 		shortTag := false
 
 		// Если текущий тег это тег без текста, то пропускаем символы до "<"
@@ -952,19 +1931,22 @@ func (self *parser) makeContent(parentTag string) string {
 
 		self.saveState()
 
+		tagPos := self.curPos
+
 		switch {
 		// Тег в котором есть текст
-		case self.curChar == '<' && self.matchTag(&tagName, &tagParams, &tagContent, &shortTag):
-			tagBuilt := self.makeTag(tagName, tagParams, tagContent, shortTag, parentTag)
-			content.WriteString(tagBuilt)
-			if _, ok := self.tagBlockType[tagName]; (ok || tagName == "br") && tagBuilt != "" {
+		case self.curChar == '<' && self.matchTag(&tagName, &tagParams, &tagParamsPos, &tagContent, &tagSource, &shortTag):
+			tagBuilt := self.makeTag(tagName, tagParams, tagContent, tagSource, shortTag, parentTag, tagPos, tagParamsPos)
+			content.add(tagBuilt...)
+			if _, ok := self.tagBlockType[tagName]; (ok || tagName == "br") && len(tagBuilt) > 0 {
 				self.skipNL(1)
 			}
-			if tagBuilt == "" {
+			if len(tagBuilt) == 0 {
 				self.skipClass(SPACE | NL)
 			}
 		// Комментарий <!-- -->
 		case self.curChar == '<' && self.matchStr("<!--"):
+			self.setReport(ReportEntry{Kind: KindComment, Action: ActionRemoved, Reason: ReasonComment, Pos: self.position(tagPos)})
 			if self.skipTextToStr("-->") {
 				self.skipStr("-->")
 				self.skipClass(SPACE | NL)
@@ -973,24 +1955,24 @@ func (self *parser) makeContent(parentTag string) string {
 		case self.curChar == '<' && self.matchTagClose(&tagName):
 			if self.curTag != "" {
 				self.restoreState()
-				return content.String()
+				return content.result()
 			} else {
-				self.setError(errors.New("Не ожидалось закрывающего тега '" + tagName + "'"))
+				self.setError(&UnexpectedCloseTagError{Tag: tagName, Pos: self.position(tagPos)})
 			}
 		// Просто символ "<"
 		case self.curChar == '<':
 			if _, ok := self.tagParentOnly[self.curTag]; !ok {
-				content.WriteString(self.entities['<'])
+				content.addText("<")
 			}
 			self.moveNextPos()
 		// Вероятно тут просто текст, формируем его
 		default:
-			content.WriteString(self.makeText(parentTag))
+			content.add(self.makeText(parentTag)...)
 		}
 		self.removeState()
 	}
 
-	return content.String()
+	return content.result()
 }
 
 //
@@ -998,21 +1980,37 @@ func (self *parser) makeContent(parentTag string) string {
 //
 // tagName *string - имя тега
 // tagParams *map[string]string - параметры тега
-// tagContent *string - контент тега
+// tagParamsPos *map[string]int - позиции параметров тега
+// tagContent *[]*Node - контент тега
+// tagSource *tagMarkup - исходная разметка тега
 // shortTag *bool - короткий ли тег
 //
-func (self *parser) matchTag(tagName *string, tagParams *map[string]string, tagContent *string, shortTag *bool) bool {
+func (self *parser) matchTag(tagName *string, tagParams *map[string]string, tagParamsPos *map[string]int, tagContent *[]*Node, tagSource *tagMarkup, shortTag *bool) bool {
 	*tagName = ""
 	*tagParams = make(map[string]string)
-	*tagContent = ""
+	*tagParamsPos = make(map[string]int)
+	*tagContent = []*Node{}
+	*tagSource = tagMarkup{}
 	*shortTag = false
 
 	closeTag := ""
+	openPos := self.curPos
 
-	if !self.matchTagOpen(tagName, tagParams, shortTag) {
+	if !self.matchTagOpen(tagName, tagParams, tagParamsPos, shortTag) {
 		return false
 	}
 
+	// Закрывающий тег ищется по исходному имени, все остальные правила применяются к итоговому
+	sourceTag := *tagName
+	*tagName, *tagParams, *tagParamsPos = self.canonicalTag(*tagName, *tagParams, *tagParamsPos, openPos)
+
+	// Пробелы и переводы строк в начале контента пропускаются, но при выводе тега как текста должны сохраниться
+	contentPos := self.curPos
+	for contentPos >= 0 && contentPos < self.textLen && (self.textBuf[contentPos] == ' ' || self.textBuf[contentPos] == '\t' || self.textBuf[contentPos] == '\n') {
+		contentPos++
+	}
+	tagSource.open = self.sourceText(openPos, contentPos)
+
 	if *shortTag {
 		return true
 	}
@@ -1024,16 +2022,39 @@ func (self *parser) matchTag(tagName *string, tagParams *map[string]string, tagC
 		self.isTypoMode = false
 	}
 
+	// Содержимое тега, который будет удален вместе с содержимым, не попадает в ошибки и отчет
+	discard := self.isDroppedWithContent(*tagName, curTag)
+	if discard {
+		self.discard++
+	}
+
+	// Маркеры ката в содержимом маркера ката вложены в него
+	isCut := self.cutTag != "" && *tagName == self.cutTag
+	if isCut {
+		self.inCut++
+	}
+
 	self.curTag = *tagName
 
 	if _, ok := self.tagPreformatted[*tagName]; ok {
-		*tagContent = self.makePreformatted(*tagName)
+		*tagContent = self.makePreformatted(sourceTag)
 	} else {
 		*tagContent = self.makeContent(*tagName)
 	}
 
-	if self.matchTagClose(&closeTag) && *tagName != closeTag {
-		self.setError(errors.New("Неверный закрывающийся тег '" + closeTag + "'. Ожидалось закрытие '" + *tagName + "'"))
+	if discard {
+		self.discard--
+	}
+	if isCut {
+		self.inCut--
+	}
+
+	closePos := self.curPos
+	if self.matchTagClose(&closeTag) {
+		if sourceTag != closeTag {
+			self.setError(&MismatchedCloseTagError{Tag: closeTag, Expected: sourceTag, Pos: self.position(closePos)})
+		}
+		tagSource.close = self.sourceText(closePos, self.curPos)
 	}
 
 	self.curTag = curTag
@@ -1047,9 +2068,10 @@ func (self *parser) matchTag(tagName *string, tagParams *map[string]string, tagC
 //
 // tagName *string - имя тега
 // tagParams *map[string]string - параметры тега
+// tagParamsPos *map[string]int - позиции параметров тега
 // shortTag *bool - короткий ли тег
 //
-func (self *parser) matchTagOpen(tagName *string, tagParams *map[string]string, shortTag *bool) bool {
+func (self *parser) matchTagOpen(tagName *string, tagParams *map[string]string, tagParamsPos *map[string]int, shortTag *bool) bool {
 	if self.curChar != '<' {
 		return false
 	}
@@ -1072,7 +2094,7 @@ func (self *parser) matchTagOpen(tagName *string, tagParams *map[string]string,
 	*tagName = strings.ToLower(*tagName)
 
 	if self.curChar != '>' && self.curChar != '/' {
-		self.matchTagParams(tagParams)
+		self.matchTagParams(tagParams, tagParamsPos)
 	}
 
 	_, *shortTag = self.tagShort[*tagName]
@@ -1145,14 +2167,16 @@ func (self *parser) matchTagClose(tagName *string) bool {
 // Обработка параметров тега
 //
 // params *map[string]string - карта параметров
+// paramsPos *map[string]int - карта позиций параметров
 //
-//
-func (self *parser) matchTagParams(params *map[string]string) bool {
+func (self *parser) matchTagParams(params *map[string]string, paramsPos *map[string]int) bool {
 	name := ""
 	value := ""
-	for self.matchTagParam(&name, &value) {
+	pos := 0
+	for self.matchTagParam(&name, &value, &pos) {
 		if []rune(name)[0] != '-' {
 			(*params)[name] = value
+			(*paramsPos)[name] = pos
 		}
 		name, value = "", ""
 	}
@@ -1164,11 +2188,13 @@ func (self *parser) matchTagParams(params *map[string]string) bool {
 //
 // name *string - имя параметра
 // value *string - значение параметра
+// pos *int - позиция параметра
 //
-func (self *parser) matchTagParam(name *string, value *string) bool {
+func (self *parser) matchTagParam(name *string, value *string, pos *int) bool {
 	self.saveState()
 	self.skipSpaces()
 
+	*pos = self.curPos
 	*name = self.grabCharClass(TAG_PARAM_NAME)
 
 	if *name == "" {
@@ -1260,8 +2286,8 @@ func (self *parser) matchTagParamValue(value *string) bool {
 //
 // openTag string - текущий открывающий тег
 //
-func (self *parser) makePreformatted(openTag string) string {
-	content := bytes.NewBufferString("")
+func (self *parser) makePreformatted(openTag string) []*Node {
+	content := strings.Builder{}
 	for self.curCharClass != NULL {
 		if self.curChar == '<' && openTag != "" {
 			closeTag := ""
@@ -1280,16 +2306,34 @@ func (self *parser) makePreformatted(openTag string) string {
 			}
 		}
 
-		if _, ok := self.entities[self.curChar]; ok {
-			content.WriteString(self.entities[self.curChar])
-		} else {
-			content.WriteString(string(self.curChar))
-		}
+		content.WriteRune(self.curChar)
 
 		self.moveNextPos()
 	}
 
-	return content.String()
+	nodes := nodeList{}
+	nodes.addText(content.String())
+	return nodes.result()
+}
+
+//
+// Будет ли тег удален вместе с содержимым. Решение принимается до обработки содержимого
+// по тем же правилам, что и в makeTag.
+//
+// tagName string - имя тега
+// parentTag string - имя тега родителя, если есть
+//
+func (self *parser) isDroppedWithContent(tagName string, parentTag string) bool {
+	if self.cutTag != "" && tagName == self.cutTag {
+		return false
+	}
+	if self.tagCutWithContent[tagName] {
+		return true
+	}
+	if _, ok := self.tagParentOnly[parentTag]; ok {
+		return !self.tagAllowed[tagName] || !self.tagChild[parentTag][tagName]
+	}
+	return false
 }
 
 //
@@ -1297,115 +2341,134 @@ func (self *parser) makePreformatted(openTag string) string {
 //
 // tagName string - имя тега
 // tagParams map[string]string - параметры тега
-// tagContent string - контент тега
+// tagContent []*Node - контент тега
+// tagSource tagMarkup - исходная разметка тега
 // shortTag bool - короткий ли тег
 // parentTag string - имя тега родителя, если есть
+// tagPos int - позиция тега
+// tagParamsPos map[string]int - позиции параметров тега
 //
-func (self *parser) makeTag(tagName string, tagParams map[string]string, tagContent string, shortTag bool, parentTag string) string {
+func (self *parser) makeTag(tagName string, tagParams map[string]string, tagContent []*Node, tagSource tagMarkup, shortTag bool, parentTag string, tagPos int, tagParamsPos map[string]int) []*Node {
 	tagName = strings.ToLower(tagName)
 
+	// Маркер ката
+	if self.cutTag != "" && tagName == self.cutTag {
+		return self.makeCut(tagContent, tagPos)
+	}
+
 	// Тег необходимо вырезать вместе с содержимым
 	if _, ok := self.tagCutWithContent[tagName]; ok {
-		return ""
+		self.dropTag(tagName, ActionRemoved, ReasonCutWithContent, tagPos)
+		return nil
 	}
 
 	// Допустим ли тег к использованию
 	if _, ok := self.tagAllowed[tagName]; !ok {
 		if _, ok := self.tagParentOnly[parentTag]; ok {
-			return ""
+			self.dropTag(tagName, ActionRemoved, ReasonNotAllowed, tagPos)
+			return nil
+		} else if self.isEscapeMode || self.tagEscape[tagName] {
+			self.dropTag(tagName, ActionEscaped, ReasonNotAllowed, tagPos)
+			return self.escapeTag(tagSource, tagContent)
 		} else {
+			self.dropTag(tagName, ActionUnwrapped, ReasonNotAllowed, tagPos)
 			return tagContent
 		}
 	}
 
 	// Должен ли тег НЕ быть дочерним к любому другому тегу
 	if _, ok := self.tagGlobalOnly[tagName]; ok && parentTag != "" {
+		self.dropTag(tagName, ActionUnwrapped, ReasonGlobalOnly, tagPos)
 		return tagContent
 	}
 
 	// Может ли тег находиться внутри родительского тега
 	if _, ok := self.tagParentOnly[parentTag]; ok {
 		if _, ok := self.tagChild[parentTag][tagName]; !ok {
-			return ""
+			self.dropTag(tagName, ActionRemoved, ReasonNotChild, tagPos)
+			return nil
 		}
 	}
 
 	// Тег может находиться только внутри другого тега
 	if _, ok := self.tagChildOnly[tagName]; ok {
 		if _, ok := self.tagParent[tagName][parentTag]; !ok {
+			self.dropTag(tagName, ActionUnwrapped, ReasonChildOnly, tagPos)
 			return tagContent
 		}
 	}
 
 	// Параметры тега
 	tagParamsResult := make(map[string]string)
+	isExternal := false
 	for param, value := range tagParams {
+		paramPos := tagPos
+		if pos, ok := tagParamsPos[param]; ok {
+			paramPos = pos
+		}
+
 		param = strings.ToLower(param)
 		value = strings.TrimSpace(value)
 
 		if value == "" {
+			self.setReport(ReportEntry{Kind: KindParam, Action: ActionRemoved, Tag: tagName, Param: param, Reason: ReasonEmptyValue, Pos: self.position(paramPos)})
 			continue
 		}
 
-		// Разрешен ли этот атрибут
-		paramAllowedValues, isParamAllowedValues := self.tagParamAllowed[tagName][param]
-
-		if !isParamAllowedValues {
+		// Разрешен ли этот атрибут у тега или у всех тегов
+		ruleTag, ruleParam, ok := self.paramRule(tagName, param)
+		if !ok {
+			pos := self.position(paramPos)
+			self.setDropError(&DroppedParamError{Tag: tagName, Param: param, Value: value, Pos: pos})
+			self.setReport(ReportEntry{Kind: KindParam, Action: ActionRemoved, Tag: tagName, Param: param, Value: value, Reason: ReasonNotAllowed, Pos: pos})
 			continue
 		}
 
-		found := false
-		for _, paramAllowedValue := range paramAllowedValues {
-			if paramAllowedValue == "#str" {
-				found = true
-				break
-			} else if paramAllowedValue == "#int" {
-				if matched, _ := regexp.MatchString(`^[0-9]+$`, value); matched {
-					continue
-				}
-				found = true
-				break
-			} else if paramAllowedValue == "#link" {
-				if matched, _ := regexp.MatchString(`javascript:`, value); matched {
-					continue
-				}
-
-				if matched, _ := regexp.MatchString(`^(?i)[a-z0-9/#]`, value); !matched {
-					continue
-				}
-
-				protocols := strings.Join(self.linkProtocolAllow, "|")
-				if matched, _ := regexp.MatchString(`^(`+protocols+`):\/\/`, value); !matched {
-					if matched, _ := regexp.MatchString(`^(\/|\#)`, value); !matched {
-						value = "http://" + value
-					}
-				}
+		origValue := value
 
+		found := false
+		for _, matcher := range self.tagParamRules[ruleTag][ruleParam] {
+			if matched, ok := matcher(self.rules, value); ok {
+				value = matched
 				found = true
 				break
-			} else if strings.HasPrefix(paramAllowedValue, "#regexp") {
-				rx := regexp.MustCompile(`^#regexp\((.*?)\)$`)
-				mc := rx.FindStringSubmatch(paramAllowedValue)
+			}
+		}
 
-				if len(mc) < 2 {
-					continue
-				}
+		if !found {
+			pos := self.position(paramPos)
+			self.setError(&InvalidParamValueError{Tag: tagName, Param: param, Value: value, Pos: pos})
+			self.setReport(ReportEntry{Kind: KindParam, Action: ActionRemoved, Tag: tagName, Param: param, Value: value, Reason: ReasonInvalidValue, Pos: pos})
+			continue
+		}
 
-				if matched, _ := regexp.MatchString(mc[1], value); !matched {
-					continue
-				}
+		// Проверка домена ссылки, разрешение относительно базового адреса и перезапись
+		if isLinkTemplates(self.tagParamAllowed[ruleTag][ruleParam]) {
+			link, external, reason := self.makeLink(tagName, param, value)
+			if reason != 0 {
+				pos := self.position(paramPos)
+				self.setError(&InvalidParamValueError{Tag: tagName, Param: param, Value: value, Pos: pos})
+				self.setReport(ReportEntry{Kind: KindParam, Action: ActionRemoved, Tag: tagName, Param: param, Value: value, Reason: reason, Pos: pos})
+				continue
+			}
+			value = link
+			isExternal = isExternal || external
+		}
 
-				found = true
-				break
-			} else if paramAllowedValue == value {
-				found = true
-				break
+		// Защита от DOM clobbering
+		if self.idPrefix != "" && (param == "id" || param == "name") {
+			id, ok := self.makeID(value)
+			if !ok {
+				pos := self.position(paramPos)
+				self.setError(&InvalidParamValueError{Tag: tagName, Param: param, Value: value, Pos: pos})
+				self.setReport(ReportEntry{Kind: KindParam, Action: ActionRemoved, Tag: tagName, Param: param, Value: value, Reason: ReasonInvalidValue, Pos: pos})
+				continue
 			}
+			value = id
 		}
 
-		if !found {
-			self.setError(errors.New("Недопустимое значение '" + value + "' для атрибута '" + param + "' тега '" + tagName + "'"))
-			continue
+		if value != origValue {
+			self.setReport(ReportEntry{Kind: KindParam, Action: ActionRewritten, Tag: tagName, Param: param, Value: origValue, Result: value, Reason: ReasonNormalized, Pos: self.position(paramPos)})
 		}
 
 		tagParamsResult[param] = value
@@ -1415,6 +2478,7 @@ func (self *parser) makeTag(tagName string, tagParams map[string]string, tagCont
 	if _, ok := self.tagParamRequired[tagName]; ok {
 		for param := range self.tagParamRequired[tagName] {
 			if _, ok := tagParamsResult[param]; !ok {
+				self.dropTag(tagName, ActionUnwrapped, ReasonRequiredParam, tagPos)
 				return tagContent
 			}
 		}
@@ -1432,59 +2496,95 @@ func (self *parser) makeTag(tagName string, tagParams map[string]string, tagCont
 	// Параметры значения которых должны быть именно такими
 	if _, ok := self.tagParamReview[tagName]; ok {
 		for param, value := range self.tagParamReview[tagName] {
+			if origValue, ok := tagParamsResult[param]; ok && origValue != value {
+				paramPos := tagPos
+				if pos, ok := tagParamsPos[param]; ok {
+					paramPos = pos
+				}
+				self.setReport(ReportEntry{Kind: KindParam, Action: ActionRewritten, Tag: tagName, Param: param, Value: origValue, Result: value, Reason: ReasonReview, Pos: self.position(paramPos)})
+			}
+			tagParamsResult[param] = value
+		}
+	}
+
+	// Параметры тегов со ссылками на внешние домены
+	if _, ok := self.tagParamExternal[tagName]; ok && isExternal {
+		for param, value := range self.tagParamExternal[tagName] {
+			if origValue, ok := tagParamsResult[param]; ok && origValue != value {
+				paramPos := tagPos
+				if pos, ok := tagParamsPos[param]; ok {
+					paramPos = pos
+				}
+				self.setReport(ReportEntry{Kind: KindParam, Action: ActionRewritten, Tag: tagName, Param: param, Value: origValue, Result: value, Reason: ReasonExternal, Pos: self.position(paramPos)})
+			}
 			tagParamsResult[param] = value
 		}
 	}
 
 	// Удаляем пустые не короткие теги если не сказано другого
 	if _, ok := self.tagEmpty[tagName]; !ok {
-		if !shortTag && tagContent == "" {
-			return ""
+		if !shortTag && len(tagContent) == 0 {
+			self.setReport(ReportEntry{Kind: KindTag, Action: ActionRemoved, Tag: tagName, Reason: ReasonEmpty, Pos: self.position(tagPos)})
+			return nil
 		}
 	}
 
-	// Вызываем callback функцию, если тег собирается именно так
-	if cb, ok := self.tagBuildCallback[tagName]; ok {
-		return cb(tagName, tagParamsResult, tagContent)
+	// Якорь заголовка и запись оглавления
+	if level := headingLevel(tagName); level > 0 && self.headingAnchors {
+		self.makeHeadingAnchor(level, tagParamsResult, self.renderHTML(tagContent, "\n"))
 	}
 
 	// Собираем тег
-	buff := bytes.NewBufferString("<" + tagName)
+	node := &Node{Kind: NodeElement, Tag: tagName, Short: shortTag, autoLink: self.isAutoLink}
 
-	for _, param := range self.tagParamSorted[tagName] {
-		if value, ok := tagParamsResult[param]; ok {
-			buff.WriteString(" " + param + "=\"" + value + "\"")
+	// Адрес автоматической ссылки выводится так же, как был в тексте, без экранирования
+	for _, param := range self.paramOrder(tagName, tagParamsResult) {
+		value := tagParamsResult[param]
+		if !self.isAutoLink {
+			value = unescapeValue(self.rules, value)
 		}
+		node.Attrs = append(node.Attrs, Attr{Name: param, Value: value})
 	}
 
-	if shortTag && self.isXHTMLMode {
-		buff.WriteString("/>")
-	} else {
-		buff.WriteString(">")
+	// Вызываем callback функцию, если тег собирается именно так
+	if cb, ok := self.tagBuildCallback[tagName]; ok {
+		node.Kind = NodeRaw
+		node.Text = cb(tagName, tagParamsResult, self.renderHTML(tagContent, "\n"))
+		node.Short = false
+		node.Children = tagContent
+		if node.Text == "" {
+			return nil
+		}
+		return []*Node{node}
 	}
 
-	if _, ok := self.tagParentOnly[tagName]; ok {
-		buff.WriteString("\n")
-	}
+	result := nodeList{}
+	result.add(node)
 
-	if !shortTag {
-		buff.WriteString(tagContent)
-		buff.WriteString("</" + tagName + ">")
+	if _, ok := self.tagParentOnly[tagName]; ok && shortTag {
+		result.addText("\n")
+	} else if !shortTag {
+		children := nodeList{}
+		if ok {
+			children.addText("\n")
+		}
+		children.add(tagContent...)
+		node.Children = children.result()
 	}
 
 	if _, ok := self.tagParentOnly[parentTag]; ok {
-		buff.WriteString("\n")
+		result.addText("\n")
 	}
 
 	if _, ok := self.tagBlockType[tagName]; ok {
-		buff.WriteString("\n")
+		result.addText("\n")
 	}
 
 	if tagName == "br" {
-		buff.WriteString("\n")
+		result.addText("\n")
 	}
 
-	return buff.String()
+	return result.result()
 }
 
 //
@@ -1616,63 +2716,60 @@ func (self *parser) matchQuote(quote *string) bool {
 //
 // parentTag string - возможный родительский тег
 //
-func (self *parser) makeText(parentTag string) string {
-	text := bytes.NewBufferString("")
+func (self *parser) makeText(parentTag string) []*Node {
+	nodes := nodeList{}
 
 	for self.curChar != '<' && self.curCharClass != NULL {
 		brCount := 0
 		spResult := ""
+		spSource := ""
 		entity := ""
 		quote := ""
 		dash := ""
 		url := ""
+		urlPos := 0
 
 		switch {
 		// Преобразование HTML сущностей
 		case self.curChar == '&' && self.matchHTMLEntity(&entity):
-			if val, ok := self.entities[[]rune(entity)[0]]; ok {
-				text.WriteString(val)
-			} else {
-				text.WriteString(entity)
-			}
+			nodes.addText(entity)
 		// Добавление символов пунктуации
 		case (self.curCharClass & PUNCTUATUON) != NULL:
-			text.WriteRune(self.curChar)
+			nodes.addRune(self.curChar)
 			self.moveNextPos()
 		// Преобразование символов тире в длинное тире
 		case self.isTypoMode && self.curChar == '-' && self.matchDash(&dash):
-			text.WriteString(dash)
+			nodes.addText(dash)
 		// Преобразование кавычек
 		case self.isTypoMode && ((self.curCharClass & TEXT_QUOTE) != NULL) && self.matchQuote(&quote):
-			text.WriteString(quote)
+			nodes.addText(quote)
 		// Преобразование пробельных символов
 		case (self.curCharClass & SPACE) != NULL:
 			self.skipSpaces()
-			text.WriteString(" ")
+			nodes.addText(" ")
 		// Преобразование символов перевода строк в тег <br>
 		case self.isAutoBrMode && ((self.curCharClass & NL) != NULL):
 			brCount = self.skipNL(-1)
 			if _, ok := self.tagNoAutoBr[self.curTag]; !ok {
-				br := self.br + "\n"
-				if brCount == 1 {
-					text.WriteString(br)
-				} else {
-					text.WriteString(br + br)
+				if brCount > 2 {
+					brCount = 2
+				}
+				for i := 0; i < brCount; i++ {
+					nodes.add(&Node{Kind: NodeElement, Tag: "br", Short: true})
+					nodes.addText("\n")
 				}
 			}
 		// Преобразование текста похожего на ссылку в кликабельную ссылку
-		case self.isAutoLinkMode && ((self.curCharClass & ALPHA) != NULL) && self.curTag != "a" && self.matchURL(&url):
-			text.WriteString(self.makeTag("a", map[string]string{"href": url}, url, false, parentTag))
+		case self.isAutoLinkMode && ((self.curCharClass & ALPHA) != NULL) && self.curTag != "a" && self.tagAllowed["a"] && self.matchURL(&url, &urlPos):
+			self.isAutoLink = true
+			nodes.add(self.makeTag("a", map[string]string{"href": url}, []*Node{{Kind: NodeText, Text: url, autoLink: true}}, tagMarkup{}, false, parentTag, urlPos, nil)...)
+			self.isAutoLink = false
 		// Вызов callback-функции если строка предварена специальным символом
-		case self.isSpecialCharMode && ((self.curCharClass & SPECIAL_CHAR) != NULL) && self.curTag != "a" && self.matchSpecialChar(&spResult):
-			text.WriteString(spResult)
+		case self.isSpecialCharMode && ((self.curCharClass & SPECIAL_CHAR) != NULL) && self.curTag != "a" && self.matchSpecialChar(&spResult, &spSource):
+			nodes.add(&Node{Kind: NodeRaw, Text: spResult, Children: []*Node{{Kind: NodeText, Text: spSource}}})
 		// Другие печатные символы
 		case ((self.curCharClass & PRINATABLE) != NULL):
-			if val, ok := self.entities[self.curChar]; ok {
-				text.WriteString(val)
-			} else {
-				text.WriteRune(self.curChar)
-			}
+			nodes.addRune(self.curChar)
 			self.moveNextPos()
 		// Не печатные символы
 		default:
@@ -1680,27 +2777,26 @@ func (self *parser) makeText(parentTag string) string {
 		}
 	}
 
-	return text.String()
+	return nodes.result()
 }
 
 //
 // Определяет текстовые ссылки
 //
 // url *string - ссылка
+// pos *int - позиция ссылки
 //
-func (self *parser) matchURL(url *string) bool {
+func (self *parser) matchURL(url *string, pos *int) bool {
 	if ((self.prevCharClass & (SPACE | NL | TEXT_QUOTE | TEXT_BRACKET)) == NULL) && self.prevCharClass != NULL {
 		return false
 	}
 
+	*pos = self.curPos
+
 	self.saveState()
 
 	switch {
-	case self.matchStr("http://") && IndexStringSlice(self.linkProtocolAllow, "http") != -1:
-		break
-	case self.matchStr("https://") && IndexStringSlice(self.linkProtocolAllow, "https") != -1:
-		break
-	case self.matchStr("ftp://") && IndexStringSlice(self.linkProtocolAllow, "ftp") != -1:
+	case self.matchLinkScheme():
 		break
 	case self.matchStr("www."):
 		*url = "http://"
@@ -1777,12 +2873,90 @@ BREAKNOW:
 	return true
 }
 
+//
+// Проверяет, начинается ли в текущей позиции ссылка с разрешенной схемой.
+// Для http, https и ftp после схемы обязательно "//", для остальных схем — любой печатный символ.
+//
+func (self *parser) matchLinkScheme() bool {
+	self.saveState()
+	defer self.restoreState()
+
+	scheme := bytes.NewBufferString("")
+	for self.curChar < 0x80 && ((self.curCharClass&(ALPHA|NUMERIC)) != NULL || (scheme.Len() > 0 && strings.ContainsRune("+-.", self.curChar))) {
+		scheme.WriteRune(self.curChar)
+		self.moveNextPos()
+	}
+
+	name := strings.ToLower(scheme.String())
+	if name == "" || self.curChar != ':' || !self.linkProtocolSet[name] {
+		return false
+	}
+
+	self.moveNextPos()
+
+	if hostSchemes[name] {
+		return self.matchStr("//")
+	}
+
+	return (self.curCharClass&PRINATABLE) != NULL && (self.curCharClass&SPACE) == NULL && self.curChar != '<'
+}
+
+//
+// Возвращает идентификатор с префиксом, уникальный в пределах парсинга
+//
+// value string - значение параметра id или name
+//
+func (self *parser) makeID(value string) (string, bool) {
+	if !idRx.MatchString(value) {
+		return value, false
+	}
+
+	if !strings.HasPrefix(value, self.idPrefix) {
+		value = self.idPrefix + value
+	}
+
+	return self.uniqueID(value), true
+}
+
+//
+// Возвращает идентификатор, не выданный ранее в пределах парсинга, добавляя суффикс -1, -2...
+// Идентификаторы внутри тегов, удаляемых вместе с содержимым, не запоминаются.
+//
+// value string - идентификатор
+//
+func (self *parser) uniqueID(value string) string {
+	if self.discard > 0 {
+		return value
+	}
+
+	if self.ids == nil {
+		self.ids = make(map[string]bool)
+		self.idsNext = make(map[string]int)
+	}
+
+	// Подбор суффикса продолжается с места, где остановился для того же идентификатора
+	id := value
+	i := self.idsNext[value]
+	if i > 0 {
+		id = value + "-" + strconv.Itoa(i)
+	}
+	for self.ids[id] {
+		i++
+		id = value + "-" + strconv.Itoa(i)
+	}
+	self.ids[id] = true
+	self.idsNext[value] = i + 1
+
+	return id
+}
+
 //
 // Определяет строки предваренные спецсимволами
 //
 // spResult *string - результат работы callback-функции
+// spSource *string - исходная строка со спецсимволом
 //
-func (self *parser) matchSpecialChar(spResult *string) bool {
+func (self *parser) matchSpecialChar(spResult *string, spSource *string) bool {
 	if (self.curCharClass & SPECIAL_CHAR) == NULL {
 		return false
 	}
@@ -1826,6 +3000,7 @@ func (self *parser) matchSpecialChar(spResult *string) bool {
 	}
 
 	*spResult = self.specialChars[spChar](buff.String())
+	*spSource = string(spChar) + buff.String()
 
 	if *spResult == "" {
 		self.restoreState()
@@ -1843,9 +3018,23 @@ func (self *parser) matchSpecialChar(spResult *string) bool {
 // msg error - сообщение об ошибке
 //
 func (self *parser) setError(msg error) {
+	if self.discard > 0 {
+		return
+	}
 	self.errorsList = append(self.errorsList, msg)
 }
 
+//
+// Добавляет ошибку об удаленном правилами теге или атрибуте, если включен режим таких ошибок
+//
+// msg error - сообщение об ошибке
+//
+func (self *parser) setDropError(msg error) {
+	if self.isDropErrorsMode {
+		self.setError(msg)
+	}
+}
+
 //
 // Сравнение двух срезов рун
 //
@@ -1874,3 +3063,50 @@ func IndexStringSlice(s []string, e string) int {
 	}
 	return -1
 }
+
+//
+// Копирование карты флагов
+//
+func cloneBoolMap(m map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+//
+// Копирование вложенной карты флагов
+//
+func cloneBoolMapMap(m map[string]map[string]bool) map[string]map[string]bool {
+	c := make(map[string]map[string]bool, len(m))
+	for k, v := range m {
+		c[k] = cloneBoolMap(v)
+	}
+	return c
+}
+
+//
+// Копирование карты строк
+//
+func cloneStringMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+//
+// Копирование вложенной карты строк
+//
+func cloneStringMapMap(m map[string]map[string]string) map[string]map[string]string {
+	c := make(map[string]map[string]string, len(m))
+	for k, v := range m {
+		c[k] = make(map[string]string, len(v))
+		for kk, vv := range v {
+			c[k][kk] = vv
+		}
+	}
+	return c
+}
@@ -1,9 +1,12 @@
 package qevix_test
 
 import (
+	"errors"
 	"net/url"
 	"qevix"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -442,3 +445,205 @@ func TestParseN26(t *testing.T) {
 		t.Errorf("Expect result to equal in func TestParseN26(t *testing.T).\n%s", result)
 	}
 }
+
+func TestCompileN1(t *testing.T) {
+	policy := qvx.Compile()
+
+	text := `<b>текст <u>текст текст`
+
+	result, _ := policy.Parse(text)
+
+	expect := `<b>текст <u>текст текст</u></b>`
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestCompileN1(t *testing.T).\n%s", result)
+	}
+}
+
+func TestCompileN2(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"b"})
+
+	policy := cfg.Compile()
+
+	cfg.CfgAllowTags([]string{"i"})
+
+	result, _ := policy.Parse(`<b>текст</b> <i>текст</i>`)
+
+	expect := `<b>текст</b> текст`
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestCompileN2(t *testing.T).\n%s", result)
+	}
+}
+
+func TestCompileN3(t *testing.T) {
+	policy := qvx.Compile()
+
+	text := `<b>"текст" текст</b> <a href="http://dighub.ru">DigHub</a> текст</i>`
+	expect := `<b>«текст» текст</b> <a href="http://dighub.ru" rel="nofollow">DigHub</a> текст`
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				result, errs := policy.Parse(text)
+				if result != expect || len(errs) != 1 {
+					t.Errorf("Expect result to equal in func TestCompileN3(t *testing.T).\n%s", result)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestValidateN1(t *testing.T) {
+	if err := qvx.Validate(); err != nil {
+		t.Errorf("Expect no error in func TestValidateN1(t *testing.T).\n%s", err)
+	}
+}
+
+func TestValidateN2(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"a", "ul", "li", "br"})
+
+	err := cfg.CfgSetTagChilds("ul", []string{"li", "lu"})
+
+	var cfgErr *qevix.ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Tag != "lu" {
+		t.Errorf("Expect ConfigError in func TestValidateN2(t *testing.T).\n%v", err)
+	}
+
+	cfg.CfgSetTagShort([]string{"br", "hr"})
+	cfg.CfgSetTagParentOnly([]string{"br"})
+	cfg.CfgSetTagChildOnly([]string{"a"})
+	cfg.CfgSetTagCutWithContent([]string{"script", "a"})
+	cfg.CfgAllowTagParams("a", []string{"href"})
+	cfg.CfgSetTagParamsRequired("a", []string{"href", "name"})
+	cfg.CfgAllowTagParamValue("a", "href", "#regexp(^(http)$)")
+	cfg.CfgAllowTagParamValue("a", "title", "#str")
+
+	err = cfg.Validate()
+
+	var errs qevix.ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expect ConfigErrors in func TestValidateN2(t *testing.T).\n%v", err)
+	}
+
+	expect := []string{
+		`qevix: CfgSetTagChilds: тег 'lu': тег отсутствует в списке разрешённых тегов`,
+		`qevix: CfgSetTagShort: тег 'hr': тег отсутствует в списке разрешённых тегов`,
+		`qevix: CfgAllowTagParamValue: тег 'a': параметр 'title': параметр отсутствует в списке разрешённых параметров`,
+		`qevix: Validate: тег 'br': тег одновременно короткий и только контейнер: короткий тег не может содержать другие теги`,
+		`qevix: Validate: тег 'a': тег одновременно разрешённый и вырезаемый вместе с содержимым: тег всегда будет вырезан`,
+		`qevix: Validate: тег 'a': параметр 'name': обязательный параметр отсутствует в списке разрешённых параметров`,
+		`qevix: Validate: тег 'a': тег может быть только дочерним, но не указан дочерним ни для одного тега`,
+		`qevix: Validate: тег 'br': тег может быть только контейнером, но для него не указаны дочерние теги`,
+	}
+
+	if len(errs) != len(expect) {
+		t.Fatalf("Expect errors to equal in func TestValidateN2(t *testing.T).\n%v", err)
+	}
+
+	for i, e := range errs {
+		if e.Error() != expect[i] {
+			t.Errorf("Expect error to equal in func TestValidateN2(t *testing.T).\n%s", e)
+		}
+	}
+}
+
+func TestValidateN3(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"img"})
+	cfg.CfgAllowTagParams("img", []string{"width"})
+	cfg.CfgAllowTagParamValue("img", "width", "#regexp(^[0-9+$)")
+
+	var cfgErr *qevix.ConfigError
+	if err := cfg.Validate(); !errors.As(err, &cfgErr) || cfgErr.Param != "width" {
+		t.Errorf("Expect ConfigError in func TestValidateN3(t *testing.T).\n%v", err)
+	}
+}
+
+//
+// Собирает конфигурацию для тестов: политика из YAML, если задана, затем вызовы Cfg*.
+// Ошибка в конфигурации прерывает тесты, чтобы не проверять поведение на неполной политике.
+//
+// policy string - политика в формате YAML или пустая строка
+// setup func(*qevix.Config) - настройка вызовами Cfg* или nil
+//
+func newQvx(policy string, setup func(cfg *qevix.Config)) *qevix.Config {
+	cfg := qevix.New()
+	if policy != "" {
+		var err error
+		if cfg, err = qevix.LoadConfig(strings.NewReader(policy)); err != nil {
+			panic(err)
+		}
+	}
+
+	if setup != nil {
+		setup(cfg)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+
+	return cfg
+}
+
+func benchmarkPolicy() *qevix.Policy {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"a", "b", "i", "img", "ul", "li", "br"})
+	cfg.CfgSetTagShort([]string{"br", "img"})
+	cfg.CfgAllowTagParams("a", []string{"href", "title", "rel"})
+	cfg.CfgAllowTagParams("img", []string{"src", "alt", "width", "height", "align"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+	cfg.CfgAllowTagParamValue("img", "src", "#link")
+	cfg.CfgAllowTagParamValue("img", "width", "#regexp(^[0-9]{1,4}$)")
+	cfg.CfgAllowTagParamValue("img", "height", "#regexp(^[0-9]{1,4}$)")
+	cfg.CfgAllowTagParamValue("img", "align", []string{"left", "right", "center"})
+	cfg.CfgSetTagParamReview("a", "rel", "nofollow")
+	cfg.CfgSetTagChilds("ul", []string{"li"})
+	cfg.CfgSetTagParentOnly([]string{"ul"})
+	cfg.CfgSetTagChildOnly([]string{"li"})
+	return cfg.Compile()
+}
+
+func benchmarkText() string {
+	chunk := `<p>Текст <b>жирный</b> и <a href="https://github.com/AlexanderGrom/go-qevix" title="Qevix">ссылка</a>, ` +
+		`<img src="http://dighub.ru/image.png" alt="Картинка" width="640" height="480" align="left"> ` +
+		`<a href="dighub.ru/page?id=1&lang=ru">ещё</a> http://yandex.ru "кавычки" - тире.</p>` + "\n" +
+		`<ul><li>пункт <i>1</i></li><li>пункт 2</li></ul>` + "\n"
+	return strings.Repeat(chunk, 200)
+}
+
+func BenchmarkParse(b *testing.B) {
+	policy := benchmarkPolicy()
+	text := benchmarkText()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(text)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		policy.Parse(text)
+	}
+}
+
+func TestValidateN4(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"b"})
+
+	for _, tags := range [][]string{{"i"}, {"i", "u"}} {
+		err := cfg.CfgSetTagShort(tags)
+
+		var errs qevix.ConfigErrors
+		var cfgErr *qevix.ConfigError
+		if !errors.As(err, &errs) || len(errs) != len(tags) || !errors.As(err, &cfgErr) || cfgErr.Tag != "i" {
+			t.Errorf("Expect ConfigErrors in func TestValidateN4(t *testing.T).\n%v", err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package qevix
+
+import (
+	"strings"
+)
+
+//
+// Правило переименования тега
+//
+type tagRename struct {
+	tag    string            // Новый тег
+	params map[string]string // Добавляемые параметры
+}
+
+//
+// Переименовывает тег и его параметры по правилам, добавляет параметры нового тега.
+// Возвращает новый тег, параметры и их позиции.
+//
+// tag string - тег
+// params map[string]string - параметры тега
+// positions map[string]int - позиции параметров тега
+// tagPos int - позиция тега
+//
+func (self *parser) renameTag(tag string, params map[string]string, positions map[string]int, tagPos int) (string, map[string]string, map[string]int) {
+	rename, isRenamed := self.tagRename[tag]
+	if isRenamed {
+		self.setReport(ReportEntry{Kind: KindTag, Action: ActionRewritten, Tag: tag, Result: rename.tag, Reason: ReasonRenamed, Pos: self.position(tagPos)})
+	}
+
+	result := make(map[string]string, len(params)+len(rename.params))
+	resultPos := make(map[string]int, len(positions)+len(rename.params))
+
+	for param, value := range params {
+		name := strings.ToLower(param)
+
+		to, ok := self.paramRename[tag][name]
+		if !ok {
+			to, ok = self.paramRename["*"][name]
+		}
+		if ok {
+			self.setReport(ReportEntry{Kind: KindParam, Action: ActionRewritten, Tag: tag, Param: name, Value: value, Result: to, Reason: ReasonRenamed, Pos: self.position(positions[param])})
+			name = to
+		}
+
+		result[name] = value
+		if pos, ok := positions[param]; ok {
+			resultPos[name] = pos
+		}
+	}
+
+	for param, value := range rename.params {
+		result[param] = escapeValue(self.rules, value)
+		resultPos[param] = tagPos
+	}
+
+	if isRenamed {
+		tag = rename.tag
+	}
+
+	return tag, result, resultPos
+}
+
+//
+// Приводит тег к итоговому имени: переименовывает тег и его параметры и сдвигает уровень заголовка.
+// Выполняется сразу после разбора открывающего тега, чтобы правила обработки содержимого
+// (преформатирование, типографирование, дочерние теги) применялись к итоговому имени.
+//
+// tag string - тег
+// params map[string]string - параметры тега
+// positions map[string]int - позиции параметров тега
+// tagPos int - позиция тега
+//
+func (self *parser) canonicalTag(tag string, params map[string]string, positions map[string]int, tagPos int) (string, map[string]string, map[string]int) {
+	if _, ok := self.tagRename[tag]; ok || len(self.paramRename) > 0 {
+		tag, params, positions = self.renameTag(tag, params, positions, tagPos)
+	}
+
+	if level := headingLevel(tag); level > 0 {
+		if heading := self.shiftHeading(level); heading != tag {
+			self.setReport(ReportEntry{Kind: KindTag, Action: ActionRewritten, Tag: tag, Result: heading, Reason: ReasonHeadingLevel, Pos: self.position(tagPos)})
+			tag = heading
+		}
+	}
+
+	return tag, params, positions
+}
@@ -0,0 +1,108 @@
+package qevix_test
+
+import (
+	"qevix"
+	"testing"
+)
+
+var renameQvx = newQvx(`
+tags:
+  strong: {}
+  em: {}
+  s: {}
+  div:
+    params: [class]
+    values:
+      class: ["#class(align-center, align-right)"]
+  span:
+    params: [data-color]
+tag_rename:
+  b: {to: strong}
+  i: {to: em}
+  strike: {to: s}
+  center:
+    to: div
+    params: {class: align-center}
+  font: {to: span}
+param_rename:
+  font: {color: data-color}
+`, nil)
+
+func TestRenameN1(t *testing.T) {
+	texts := map[string]string{
+		`<B>a</B> <i>b</i> <strike>c</strike>`:    `<strong>a</strong> <em>b</em> <s>c</s>`,
+		`<center>a</center>`:                      `<div class="align-center">a</div>`,
+		`<center class="align-right">a</center>`:  `<div class="align-center">a</div>`,
+		`<font color="red" face="Arial">a</font>`: `<span data-color="red">a</span>`,
+		`<span color="red">a</span>`:              `<span>a</span>`,
+		`<div class="align-right"><b>a</b></div>`: `<div class="align-right"><strong>a</strong></div>`,
+	}
+
+	for text, expect := range texts {
+		result, _ := renameQvx.Parse(text)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestRenameN1(t *testing.T).\n%s: %s", text, result)
+		}
+	}
+}
+
+func TestRenameN2(t *testing.T) {
+	result := renameQvx.ParseResult(`<b>a</b><font color="red">b</font>`)
+
+	expect := []string{
+		`1:1: Тег <b> изменен на 'strong': переименован правилами`,
+		`1:9: Тег <font> изменен на 'span': переименован правилами`,
+		`1:15: Атрибут 'color' тега <font> изменен на 'data-color': переименован правилами`,
+	}
+
+	if len(result.Report) != len(expect) {
+		t.Fatalf("Expect report to equal in func TestRenameN2(t *testing.T).\n%v", result.Report)
+	}
+
+	for i, entry := range result.Report {
+		if entry.String() != expect[i] {
+			t.Errorf("Expect report to equal in func TestRenameN2(t *testing.T).\n%s", entry)
+		}
+	}
+
+	cfg := qevix.New()
+
+	if err := cfg.CfgSetTagRename("b", "bad tag", nil); err == nil {
+		t.Errorf("Expect error in func TestRenameN2(t *testing.T).\n%s", "bad tag")
+	}
+
+	if err := cfg.CfgSetParamRename("*", "color", "on click"); err == nil {
+		t.Errorf("Expect error in func TestRenameN2(t *testing.T).\n%s", "on click")
+	}
+}
+
+func TestRenameN3(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"ul", "li", "pre", "br"})
+	cfg.CfgSetTagShort([]string{"br"})
+	cfg.CfgSetTagBlockType([]string{"ul", "li", "pre"})
+	cfg.CfgSetTagPreformatted([]string{"pre"})
+	cfg.CfgSetTagNoTypography([]string{"pre"})
+	cfg.CfgSetTagParentOnly([]string{"ul"})
+	cfg.CfgSetTagChildOnly([]string{"li"})
+	cfg.CfgSetTagChilds("ul", []string{"li"})
+	cfg.CfgSetTagRename("dir", "ul", nil)
+	cfg.CfgSetTagRename("xmp", "pre", nil)
+	cfg.CfgSetAutoBrMode(true)
+
+	texts := map[string]string{
+		`<dir><li>a</li><li>b</li></dir>`: `<ul><li>a</li><li>b</li></ul>`,
+		"<xmp>a -- b\n<c></xmp>":          "<pre>a -- b\n<c></pre>",
+	}
+
+	// Переименованный тег обрабатывается так же, как написанный сразу итоговым именем
+	for text, expect := range texts {
+		result, _ := cfg.Parse(text)
+		canonical, _ := cfg.Parse(expect)
+
+		if result != canonical {
+			t.Errorf("Expect result to equal in func TestRenameN3(t *testing.T).\n%s: %s", text, result)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package qevix
+
+//
+// Что было изменено
+//
+type ReportKind int
+
+const (
+	KindTag     ReportKind = iota + 1 // Тег
+	KindParam                         // Атрибут тега
+	KindComment                       // Комментарий
+)
+
+//
+// Как было изменено
+//
+type ReportAction int
+
+const (
+	ActionRemoved   ReportAction = iota + 1 // Удален вместе с содержимым
+	ActionUnwrapped                         // Удален, содержимое оставлено
+	ActionRewritten                         // Значение заменено
+	ActionEscaped                           // Выведен как текст
+)
+
+//
+// Запись отчета об изменении текста
+//
+type ReportEntry struct {
+	Kind   ReportKind
+	Action ReportAction
+	Tag    string   // Тег
+	Param  string   // Атрибут, если изменялся атрибут
+	Value  string   // Исходное значение атрибута
+	Result string   // Новое значение атрибута, если значение заменено
+	Reason Reason   // Причина изменения
+	Pos    Position // Позиция во входном тексте
+}
+
+func (self ReportEntry) String() string {
+	msg := self.Pos.String() + ": "
+
+	switch self.Kind {
+	case KindComment:
+		msg += "Комментарий"
+	case KindParam:
+		msg += "Атрибут '" + self.Param + "' тега <" + self.Tag + ">"
+	default:
+		msg += "Тег <" + self.Tag + ">"
+	}
+
+	switch self.Action {
+	case ActionRemoved:
+		msg += " удален"
+	case ActionUnwrapped:
+		msg += " удален, содержимое оставлено"
+	case ActionRewritten:
+		msg += " изменен на '" + self.Result + "'"
+	case ActionEscaped:
+		msg += " выведен как текст"
+	}
+
+	return msg + ": " + self.Reason.String()
+}
+
+//
+// Отчет обо всех удаленных и измененных тегах, атрибутах и комментариях
+//
+type Report []ReportEntry
+
+//
+// Результат разбора текста
+//
+type Result struct {
+	Content string    // Отфильтрованный текст
+	Errors  []error   // Ошибки в разметке
+	Report  Report    // Отчет об изменениях
+	TOC     TOC       // Оглавление, если включены якоря заголовков
+	Teaser  string    // Текст до маркера ката, если он задан и найден
+	Cut     *Position // Позиция маркера ката во входном тексте, nil если маркер не найден
+}
+
+//
+// Парсинг строки с отчетом об изменениях
+//
+// Вызов безопасен из нескольких горутин
+//
+// text string - входная строка для парсинга
+//
+func (self *Policy) ParseResult(text string) Result {
+	p := self.pool.Get().(*parser)
+	defer self.pool.Put(p)
+
+	result, _ := p.parse(text)
+
+	return result
+}
+
+//
+// Добавляет запись в отчет
+//
+// entry ReportEntry - запись
+//
+func (self *parser) setReport(entry ReportEntry) {
+	if self.discard > 0 {
+		return
+	}
+	self.report = append(self.report, entry)
+}
+
+//
+// Отмечает тег удаленным, добавляя запись в отчет и ошибку, если включен режим ошибок удаления
+//
+// tagName string - тег
+// action ReportAction - удален ли тег вместе с содержимым
+// reason Reason - причина удаления
+// tagPos int - позиция тега
+//
+func (self *parser) dropTag(tagName string, action ReportAction, reason Reason, tagPos int) {
+	pos := self.position(tagPos)
+	self.setDropError(&DroppedTagError{Tag: tagName, Reason: reason, Pos: pos})
+	self.setReport(ReportEntry{Kind: KindTag, Action: action, Tag: tagName, Reason: reason, Pos: pos})
+}
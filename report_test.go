@@ -0,0 +1,97 @@
+package qevix_test
+
+import (
+	"qevix"
+	"testing"
+)
+
+var reportQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgAllowTags([]string{"a", "b", "ul", "li"})
+	cfg.CfgSetTagCutWithContent([]string{"iframe"})
+	cfg.CfgAllowTagParams("a", []string{"href", "rel"})
+	cfg.CfgSetTagParamsRequired("a", []string{"href"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+	cfg.CfgSetTagParamReview("a", "rel", "nofollow")
+	cfg.CfgSetTagChilds("ul", []string{"li"})
+	cfg.CfgSetTagParentOnly([]string{"ul"})
+})
+
+func TestReportN1(t *testing.T) {
+	text := `<!-- комментарий --><iframe src="x"></iframe><s>текст</s> <b></b>`
+
+	result := reportQvx.ParseResult(text)
+
+	expect := []qevix.ReportEntry{
+		{Kind: qevix.KindComment, Action: qevix.ActionRemoved, Reason: qevix.ReasonComment},
+		{Kind: qevix.KindTag, Action: qevix.ActionRemoved, Tag: "iframe", Reason: qevix.ReasonCutWithContent},
+		{Kind: qevix.KindTag, Action: qevix.ActionUnwrapped, Tag: "s", Reason: qevix.ReasonNotAllowed},
+		{Kind: qevix.KindTag, Action: qevix.ActionRemoved, Tag: "b", Reason: qevix.ReasonEmpty},
+	}
+
+	if result.Content != "текст" || len(result.Report) != len(expect) {
+		t.Fatalf("Expect report to equal in func TestReportN1(t *testing.T).\n%v", result.Report)
+	}
+
+	for i, entry := range result.Report {
+		entry.Pos = qevix.Position{}
+		if entry != expect[i] {
+			t.Errorf("Expect report to equal in func TestReportN1(t *testing.T).\n%v", result.Report[i])
+		}
+	}
+}
+
+func TestReportN2(t *testing.T) {
+	text := `<a href="dighub.ru" rel="me" name="top">текст</a> <a title="">текст</a>`
+
+	result := reportQvx.ParseResult(text)
+
+	expect := []string{
+		`1:4: Атрибут 'href' тега <a> изменен на 'http://dighub.ru': значение приведено к допустимому виду`,
+		`1:21: Атрибут 'rel' тега <a> изменен на 'nofollow': значение задано правилами`,
+		`1:30: Атрибут 'name' тега <a> удален: отсутствует в списке разрешённых`,
+		`1:51: Тег <a> удален, содержимое оставлено: отсутствует обязательный атрибут`,
+		`1:54: Атрибут 'title' тега <a> удален: пустое значение`,
+	}
+
+	if len(result.Report) != len(expect) {
+		t.Fatalf("Expect report to equal in func TestReportN2(t *testing.T).\n%v", result.Report)
+	}
+
+	for i, entry := range result.Report {
+		if entry.String() != expect[i] {
+			t.Errorf("Expect report to equal in func TestReportN2(t *testing.T).\n%s", entry)
+		}
+	}
+}
+
+func TestReportN3(t *testing.T) {
+	text := "<ul>\n<li>текст</li>\n<b>текст</b>\n</ul>"
+
+	result := reportQvx.ParseResult(text)
+
+	if len(result.Report) != 1 || result.Report[0].Action != qevix.ActionRemoved || result.Report[0].Reason != qevix.ReasonNotChild || result.Report[0].Pos.Line != 3 {
+		t.Errorf("Expect report to equal in func TestReportN3(t *testing.T).\n%v", result.Report)
+	}
+}
+
+func TestReportN4(t *testing.T) {
+	text := `<iframe><s>текст</s> <a title="">текст</a></iframe><ul><b><s>текст</s></b></ul>`
+
+	result := reportQvx.ParseResult(text)
+
+	expect := []string{
+		`1:1: Тег <iframe> удален: вырезается вместе с содержимым`,
+		`1:52: Тег <ul> удален: пустой тег`,
+		`1:56: Тег <b> удален: не может находиться внутри родительского тега`,
+	}
+
+	if len(result.Errors) != 0 || len(result.Report) != len(expect) {
+		t.Fatalf("Expect report to equal in func TestReportN4(t *testing.T).\n%v\n%v", result.Errors, result.Report)
+	}
+
+	for i, entry := range result.Report {
+		if entry.String() != expect[i] {
+			t.Errorf("Expect report to equal in func TestReportN4(t *testing.T).\n%s", entry)
+		}
+	}
+}
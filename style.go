@@ -0,0 +1,112 @@
+package qevix
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	cssPropertyRx   = regexp.MustCompile(`^-?[a-z][a-z0-9\-]*$`)
+	cssImportantRx  = regexp.MustCompile(`(?i)\s*!\s*important$`)
+	cssForbiddenRx  = regexp.MustCompile(`(?i)(url|expression|image-set|-moz-binding|behavior)\s*\(|@import|javascript:|[\\<>{}]|/\*`)
+	cssHexColorRx   = regexp.MustCompile(`^#([0-9a-f]{3}|[0-9a-f]{4}|[0-9a-f]{6}|[0-9a-f]{8})$`)
+	cssFuncColorRx  = regexp.MustCompile(`^(rgba?|hsla?)\(\s*[0-9.+\-]+(%|deg)?(\s*[,/\s]\s*[0-9.+\-]+%?){2,3}\s*\)$`)
+	cssNamedColorRx = regexp.MustCompile(`^[a-z]{3,20}$`)
+)
+
+//
+// Шаблон #css-color, цвет CSS: #rgb, #rrggbb, rgb(), rgba(), hsl(), hsla() или название цвета
+//
+func matchCSSColorValue(r *rules, value string) (string, bool) {
+	color := strings.ToLower(strings.TrimSpace(value))
+	if cssHexColorRx.MatchString(color) || cssFuncColorRx.MatchString(color) || cssNamedColorRx.MatchString(color) {
+		return color, true
+	}
+	return value, false
+}
+
+//
+// Шаблон #style, встроенные стили.
+// Оставляет только разрешенные свойства с допустимыми значениями и собирает их в каноническом виде:
+// "свойство: значение; свойство: значение". Объявления с url(), expression(), @import,
+// экранированием и комментариями удаляются целиком.
+//
+func matchStyleValue(r *rules, value string) (string, bool) {
+	style := html.UnescapeString(html.UnescapeString(value))
+
+	result := []string{}
+	for _, declaration := range splitCSSDeclarations(style) {
+		colon := strings.IndexByte(declaration, ':')
+		if colon == -1 {
+			continue
+		}
+
+		property := strings.ToLower(strings.TrimSpace(declaration[:colon]))
+		propertyValue := strings.TrimSpace(cssImportantRx.ReplaceAllString(declaration[colon+1:], ""))
+
+		if !cssPropertyRx.MatchString(property) || propertyValue == "" || cssForbiddenRx.MatchString(propertyValue) {
+			continue
+		}
+		if strings.IndexFunc(propertyValue, isControlRune) != -1 {
+			continue
+		}
+
+		for _, matcher := range r.styleRules[property] {
+			if matched, ok := matcher(r, propertyValue); ok {
+				result = append(result, property+": "+matched)
+				break
+			}
+		}
+	}
+
+	if len(result) == 0 {
+		return value, false
+	}
+
+	return escapeValue(r, strings.Join(result, "; ")), true
+}
+
+//
+// Разбивает встроенные стили на объявления по ";" вне скобок и кавычек
+//
+// style string - встроенные стили
+//
+func splitCSSDeclarations(style string) []string {
+	declarations := []string{}
+	depth := 0
+	quote := rune(0)
+	start := 0
+
+	for i, char := range style {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case char == '(':
+			depth++
+		case char == ')' && depth > 0:
+			depth--
+		case char == ';' && depth == 0:
+			declarations = append(declarations, style[start:i])
+			start = i + 1
+		}
+	}
+
+	// Незакрытые кавычки или скобки делают последнее объявление недопустимым
+	if quote == 0 && depth == 0 {
+		declarations = append(declarations, style[start:])
+	}
+
+	return declarations
+}
+
+//
+// Управляющий символ
+//
+func isControlRune(char rune) bool {
+	return char < 0x20 || char == 0x7f
+}
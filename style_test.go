@@ -0,0 +1,75 @@
+package qevix_test
+
+import (
+	"qevix"
+	"testing"
+)
+
+var styleQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgAllowTags([]string{"span", "p"})
+	cfg.CfgAllowTagParams("span", []string{"style"})
+	cfg.CfgAllowTagParamValue("span", "style", "#style")
+	cfg.CfgAllowTagParams("p", []string{"style"})
+	cfg.CfgAllowTagParamValue("p", "style", "#style")
+	cfg.CfgAllowStyleProperty("color", "#css-color")
+	cfg.CfgAllowStyleProperty("text-align", []string{"left", "right", "center", "justify"})
+	cfg.CfgAllowStyleProperty("font-weight", []string{"normal", "bold", "#int(100,900)"})
+	cfg.CfgAllowStyleProperty("margin-left", "#length(0,200)")
+	cfg.CfgAllowStyleProperty("font-family", "#str")
+})
+
+func TestStyleN1(t *testing.T) {
+	text := `<span style="COLOR: Red ; text-align:center;font-weight: 700 !important; position: absolute">a</span>`
+
+	expect := `<span style="color: red; text-align: center; font-weight: 700">a</span>`
+
+	result, _ := styleQvx.Parse(text)
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestStyleN1(t *testing.T).\n%s", result)
+	}
+}
+
+func TestStyleN2(t *testing.T) {
+	styles := map[string]string{
+		`color: #FF0000; margin-left: 20px`:                      `<p style="color: #ff0000; margin-left: 20px">a</p>`,
+		`color: rgb(255, 0, 0)`:                                  `<p style="color: rgb(255, 0, 0)">a</p>`,
+		`color: red; background: url(javascript:alert(1))`:       `<p style="color: red">a</p>`,
+		`font-family: url(http://evil.example/x.woff)`:           `<p>a</p>`,
+		`font-family: expression(alert(1))`:                      `<p>a</p>`,
+		`font-family: "a;b"; color: blue`:                        `<p style="font-family: &#34;a;b&#34;; color: blue">a</p>`,
+		`font-family: \75 rl(x)`:                                 `<p>a</p>`,
+		`font-family: a /* x */`:                                 `<p>a</p>`,
+		`@import "x.css"; color: blue`:                           `<p style="color: blue">a</p>`,
+		`color: red; font-family: "a`:                            `<p style="color: red">a</p>`,
+		`margin-left: 500px; text-align: middle; font-weight: 5`: `<p>a</p>`,
+		`color: &#114;ed`:                                        `<p style="color: red">a</p>`,
+	}
+
+	for style, expect := range styles {
+		result, _ := styleQvx.Parse(`<p style='` + style + `'>a</p>`)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestStyleN2(t *testing.T).\n%s: %s", style, result)
+		}
+	}
+}
+
+func TestStyleN3(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"span"})
+	cfg.CfgAllowTagParams("span", []string{"style"})
+	cfg.CfgAllowTagParamValue("span", "style", "#style")
+
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expect error in func TestStyleN3(t *testing.T).\n%s", "no properties")
+	}
+
+	if err := cfg.CfgAllowStyleProperty("Color", "#str"); err == nil {
+		t.Errorf("Expect error in func TestStyleN3(t *testing.T).\n%s", "Color")
+	}
+
+	if err := cfg.CfgAllowStyleProperty("color", "#style"); err == nil {
+		t.Errorf("Expect error in func TestStyleN3(t *testing.T).\n%s", "#style")
+	}
+}
@@ -0,0 +1,137 @@
+package qevix
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	headingTagRx = regexp.MustCompile(`<[^>]*>`)
+)
+
+//
+// Запись оглавления
+//
+type TOCEntry struct {
+	Level  int    // Уровень заголовка, 1-6
+	Text   string // Текст заголовка без тегов, не экранирован
+	Anchor string // Идентификатор заголовка, значение параметра id
+}
+
+//
+// Оглавление текста в порядке следования заголовков
+//
+type TOC []TOCEntry
+
+//
+// Транслитерация кириллицы для якорей
+//
+var slugTranslit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+}
+
+//
+// Возвращает уровень заголовка h1-h6 или 0, если тег не заголовок
+//
+// tag string - тег
+//
+func headingLevel(tag string) int {
+	if len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6' {
+		return int(tag[1] - '0')
+	}
+	return 0
+}
+
+//
+// Возвращает тег заголовка с уровнем, сдвинутым и ограниченным правилами
+//
+// level int - исходный уровень заголовка
+//
+func (self *rules) shiftHeading(level int) string {
+	level += self.headingShift
+	if level < self.headingMin {
+		level = self.headingMin
+	}
+	if level > self.headingMax {
+		level = self.headingMax
+	}
+	return "h" + strconv.Itoa(level)
+}
+
+//
+// Возвращает текст заголовка: без тегов, с раскрытыми сущностями и схлопнутыми пробелами
+//
+// content string - содержимое тега
+//
+func headingText(content string) string {
+	text := html.UnescapeString(headingTagRx.ReplaceAllString(content, " "))
+	return strings.Join(strings.FieldsFunc(text, unicode.IsSpace), " ")
+}
+
+//
+// Создает якорь из текста: транслитерирует кириллицу, приводит к нижнему регистру,
+// заменяет все кроме букв и цифр на дефис
+//
+// text string - текст заголовка
+//
+func slugify(text string) string {
+	buff := strings.Builder{}
+	dash := false
+
+	for _, r := range strings.ToLower(text) {
+		if s, ok := slugTranslit[r]; ok {
+			buff.WriteString(s)
+			dash = false
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			buff.WriteRune(r)
+			dash = false
+		} else if !dash && buff.Len() > 0 {
+			buff.WriteByte('-')
+			dash = true
+		}
+	}
+
+	return strings.TrimRight(buff.String(), "-")
+}
+
+//
+// Добавляет заголовку якорь, если параметр id не задан, и записывает заголовок в оглавление.
+// Заданный id, как и созданный якорь, не должен повторять уже выданные идентификаторы.
+// Заголовки внутри тегов, удаляемых вместе с содержимым, в оглавление не попадают.
+//
+// level int - уровень заголовка
+// params map[string]string - параметры тега
+// content string - содержимое тега
+//
+func (self *parser) makeHeadingAnchor(level int, params map[string]string, content string) {
+	if self.discard > 0 {
+		return
+	}
+
+	text := headingText(content)
+
+	anchor, ok := params["id"]
+	if ok {
+		// С префиксом идентификаторов id уже проверен на повторы в makeID
+		if self.idPrefix == "" {
+			anchor = self.uniqueID(anchor)
+			params["id"] = anchor
+		}
+	} else {
+		slug := slugify(text)
+		if slug == "" {
+			slug = "section"
+		}
+		anchor = self.uniqueID(self.idPrefix + slug)
+		params["id"] = anchor
+	}
+
+	self.toc = append(self.toc, TOCEntry{Level: level, Text: text, Anchor: anchor})
+}
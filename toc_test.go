@@ -0,0 +1,153 @@
+package qevix_test
+
+import (
+	"qevix"
+	"reflect"
+	"testing"
+)
+
+var tocQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgAllowTags([]string{"h1", "h2", "h3", "b"})
+	cfg.CfgAllowTagParams("h2", []string{"id"})
+	cfg.CfgSetHeadingAnchors(true)
+})
+
+func TestTOCN1(t *testing.T) {
+	text := "<h1>Введение в Go</h1><h2>Щука &amp; ёж</h2><h2>Щука &amp; ёж</h2><h3><b>Step</b> 2: done!</h3><h2 id=\"own\">Свой</h2><h3>!!!</h3>"
+
+	expect := `<h1 id="vvedenie-v-go">Введение в Go</h1><h2 id="shchuka-yozh">Щука &#38; ёж</h2><h2 id="shchuka-yozh-1">Щука &#38; ёж</h2><h3 id="step-2-done"><b>Step</b> 2: done!</h3><h2 id="own">Свой</h2><h3 id="section">!!!</h3>`
+	expectTOC := qevix.TOC{
+		{Level: 1, Text: "Введение в Go", Anchor: "vvedenie-v-go"},
+		{Level: 2, Text: "Щука & ёж", Anchor: "shchuka-yozh"},
+		{Level: 2, Text: "Щука & ёж", Anchor: "shchuka-yozh-1"},
+		{Level: 3, Text: "Step 2: done!", Anchor: "step-2-done"},
+		{Level: 2, Text: "Свой", Anchor: "own"},
+		{Level: 3, Text: "!!!", Anchor: "section"},
+	}
+
+	result := tocQvx.ParseResult(text)
+
+	if result.Content != expect {
+		t.Errorf("Expect result to equal in func TestTOCN1(t *testing.T).\n%s", result.Content)
+	}
+
+	if !reflect.DeepEqual(result.TOC, expectTOC) {
+		t.Errorf("Expect toc to equal in func TestTOCN1(t *testing.T).\n%v", result.TOC)
+	}
+
+	result = tocQvx.ParseResult("<h1>Введение в Go</h1>")
+
+	if len(result.TOC) != 1 || result.TOC[0].Anchor != "vvedenie-v-go" {
+		t.Errorf("Expect toc to reset in func TestTOCN1(t *testing.T).\n%v", result.TOC)
+	}
+}
+
+func TestTOCN2(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"h2", "a"})
+	cfg.CfgAllowTagParams("a", []string{"href"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+	cfg.CfgSetHeadingAnchors(true)
+	cfg.CfgSetIDPrefix("user-content-")
+
+	text := `<h2>Итоги</h2><a href="#itogi">a</a>`
+	expect := `<h2 id="user-content-itogi">Итоги</h2><a href="#user-content-itogi">a</a>`
+
+	result := cfg.ParseResult(text)
+
+	if result.Content != expect {
+		t.Errorf("Expect result to equal in func TestTOCN2(t *testing.T).\n%s", result.Content)
+	}
+
+	if len(result.TOC) != 1 || result.TOC[0].Anchor != "user-content-itogi" {
+		t.Errorf("Expect toc to equal in func TestTOCN2(t *testing.T).\n%v", result.TOC)
+	}
+
+	if result := qevix.New().ParseResult("<h2>a</h2>"); result.TOC != nil {
+		t.Errorf("Expect no toc in func TestTOCN2(t *testing.T).\n%v", result.TOC)
+	}
+}
+
+func TestTOCN3(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"h3", "h4", "h5", "h6"})
+	cfg.CfgSetHeadingShift(2)
+
+	texts := map[string]string{
+		`<h1>a</h1>`: `<h3>a</h3>`,
+		`<h2>a</h2>`: `<h4>a</h4>`,
+		`<h5>a</h5>`: `<h6>a</h6>`,
+		`<H6>a</H6>`: `<h6>a</h6>`,
+	}
+
+	for text, expect := range texts {
+		result, _ := cfg.Parse(text)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestTOCN3(t *testing.T).\n%s: %s", text, result)
+		}
+	}
+
+	result := cfg.ParseResult(`<h1>a</h1>`)
+	if len(result.Report) != 1 || result.Report[0].Reason != qevix.ReasonHeadingLevel || result.Report[0].Result != "h3" {
+		t.Errorf("Expect report to equal in func TestTOCN3(t *testing.T).\n%v", result.Report)
+	}
+
+	if err := cfg.CfgSetHeadingShift(6); err == nil {
+		t.Errorf("Expect error in func TestTOCN3(t *testing.T).\n%d", 6)
+	}
+}
+
+func TestTOCN4(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"h3", "h4"})
+	cfg.CfgSetHeadingRange(3, 4)
+	cfg.CfgSetHeadingAnchors(true)
+
+	text := `<h1>Раз</h1><h2>Два</h2><h5>Три</h5>`
+	expect := `<h3 id="raz">Раз</h3><h3 id="dva">Два</h3><h4 id="tri">Три</h4>`
+
+	result := cfg.ParseResult(text)
+
+	if result.Content != expect {
+		t.Errorf("Expect result to equal in func TestTOCN4(t *testing.T).\n%s", result.Content)
+	}
+
+	if len(result.TOC) != 3 || result.TOC[0].Level != 3 || result.TOC[2].Level != 4 {
+		t.Errorf("Expect toc to equal in func TestTOCN4(t *testing.T).\n%v", result.TOC)
+	}
+
+	if err := cfg.CfgSetHeadingRange(5, 2); err == nil {
+		t.Errorf("Expect error in func TestTOCN4(t *testing.T).\n%s", "5-2")
+	}
+}
+
+func TestTOCN5(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgApplySpec(tocQvx.Spec())
+	cfg.CfgSetTagCutWithContent([]string{"script"})
+
+	tests := []struct {
+		text   string
+		expect string
+		toc    []string
+	}{
+		{`<h2>Intro</h2><h2 id="intro">X</h2>`, `<h2 id="intro">Intro</h2><h2 id="intro-1">X</h2>`, []string{"intro", "intro-1"}},
+		{`<h2 id="intro">X</h2><h2>Intro</h2>`, `<h2 id="intro">X</h2><h2 id="intro-1">Intro</h2>`, []string{"intro", "intro-1"}},
+		{`<script><h2>Ghost</h2></script><h2>Ghost</h2>`, `<h2 id="ghost">Ghost</h2>`, []string{"ghost"}},
+		{`<h2>A</h2><h2>A</h2><h2 id="a-1">B</h2><h2>A</h2>`, `<h2 id="a">A</h2><h2 id="a-1">A</h2><h2 id="a-1-1">B</h2><h2 id="a-2">A</h2>`, []string{"a", "a-1", "a-1-1", "a-2"}},
+	}
+
+	for _, test := range tests {
+		result := cfg.ParseResult(test.text)
+
+		anchors := []string{}
+		for _, entry := range result.TOC {
+			anchors = append(anchors, entry.Anchor)
+		}
+
+		if result.Content != test.expect || !reflect.DeepEqual(anchors, test.toc) {
+			t.Errorf("Expect result to equal in func TestTOCN5(t *testing.T).\n%s: %s %v", test.text, result.Content, anchors)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package qevix
+
+import (
+	"strings"
+	"unicode"
+)
+
+//
+// Тип узла дерева
+//
+type NodeKind int
+
+const (
+	NodeElement NodeKind = iota + 1 // Тег
+	NodeText                        // Текст
+	NodeRaw                         // HTML, собранный callback-функцией, выводится как есть
+)
+
+//
+// Атрибут тега
+//
+type Attr struct {
+	Name  string // Имя атрибута
+	Value string // Значение атрибута, не экранировано
+}
+
+//
+// Узел дерева разобранного текста.
+// Узел NodeRaw хранит в Text результат callback-функции, а в Tag, Attrs и Children — тег,
+// из которого он собран, или исходный текст для callback-функции спецсимвола.
+//
+type Node struct {
+	Kind     NodeKind
+	Tag      string  // Тег, для NodeElement и NodeRaw
+	Attrs    []Attr  // Атрибуты в порядке правил политики, для NodeElement и NodeRaw
+	Short    bool    // Короткий тег без закрывающего тега, дочерние узлы выводятся следом за ним, для NodeElement
+	Text     string  // Текст без экранирования, переводы строк "\n", для NodeText; HTML для NodeRaw
+	Children []*Node // Дочерние узлы, для NodeElement и NodeRaw
+
+	autoLink bool // Автоматическая ссылка или ее текст: адрес выводится так же, как был в тексте
+}
+
+//
+// Возвращает значение атрибута
+//
+// name string - имя атрибута
+//
+func (self *Node) Attr(name string) (string, bool) {
+	for _, attr := range self.Attrs {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+//
+// Задает значение атрибута, новый атрибут добавляется в конец
+//
+// name string - имя атрибута
+// value string - значение атрибута без экранирования
+//
+func (self *Node) SetAttr(name string, value string) {
+	for i := range self.Attrs {
+		if self.Attrs[i].Name == name {
+			self.Attrs[i].Value = value
+			return
+		}
+	}
+	self.Attrs = append(self.Attrs, Attr{Name: name, Value: value})
+}
+
+//
+// Обходит дерево в глубину в порядке следования узлов.
+// Дочерние узлы посещаются, только если visitor вернул true.
+//
+// nodes []*Node - узлы
+// visitor func(*Node) bool - функция, вызываемая для каждого узла
+//
+func Walk(nodes []*Node, visitor func(*Node) bool) {
+	for _, node := range nodes {
+		if visitor(node) && node.Kind == NodeElement {
+			Walk(node.Children, visitor)
+		}
+	}
+}
+
+//
+// Парсинг строки в дерево узлов.
+// Дерево собирается при парсинге, отфильтрованный текст Parse получается из него же.
+//
+// Вызов безопасен из нескольких горутин
+//
+// text string - входная строка для парсинга
+//
+func (self *Policy) ParseTree(text string) ([]*Node, []error) {
+	p := self.pool.Get().(*parser)
+	defer self.pool.Put(p)
+
+	result, nodes := p.parse(text)
+
+	return nodes, result.Errors
+}
+
+//
+// Собирает HTML из дерева узлов с учетом режима XHTML и символов перевода строки политики
+//
+// nodes []*Node - узлы
+//
+func (self *Policy) Render(nodes []*Node) string {
+	return self.renderHTML(nodes, self.nl)
+}
+
+//
+// Собирает HTML из узлов
+//
+// nodes []*Node - узлы
+// nl string - символы перевода строки
+//
+func (self *rules) renderHTML(nodes []*Node, nl string) string {
+	buff := strings.Builder{}
+	self.renderNodes(&buff, nodes, nl)
+	return buff.String()
+}
+
+//
+// Собирает HTML из узлов в буфер
+//
+// buff *strings.Builder - буфер
+// nodes []*Node - узлы
+// nl string - символы перевода строки
+//
+func (self *rules) renderNodes(buff *strings.Builder, nodes []*Node, nl string) {
+	for _, node := range nodes {
+		if node.Kind == NodeText && node.autoLink {
+			buff.WriteString(node.Text)
+			continue
+		}
+
+		if node.Kind == NodeText {
+			buff.WriteString(strings.Replace(escapeValue(self, node.Text), "\n", nl, -1))
+			continue
+		}
+
+		if node.Kind == NodeRaw {
+			buff.WriteString(strings.Replace(node.Text, "\n", nl, -1))
+			continue
+		}
+
+		buff.WriteString("<" + node.Tag)
+		for _, attr := range node.Attrs {
+			value := attr.Value
+			if !node.autoLink {
+				value = escapeValue(self, value)
+			}
+			buff.WriteString(" " + attr.Name + "=\"" + value + "\"")
+		}
+
+		if node.Short {
+			if self.isXHTMLMode {
+				buff.WriteString("/>")
+			} else {
+				buff.WriteString(">")
+			}
+			self.renderNodes(buff, node.Children, nl)
+			continue
+		}
+
+		buff.WriteString(">")
+		self.renderNodes(buff, node.Children, nl)
+		buff.WriteString("</" + node.Tag + ">")
+	}
+}
+
+//
+// Список узлов, собираемый при парсинге. Текст копится в буфере и попадает в список одним узлом,
+// когда добавляется тег или список готов, поэтому соседний текст объединяется без повторного копирования.
+//
+type nodeList struct {
+	nodes []*Node
+	text  strings.Builder
+}
+
+//
+// Добавляет текст без экранирования
+//
+// text string - текст
+//
+func (self *nodeList) addText(text string) {
+	self.text.WriteString(text)
+}
+
+//
+// Добавляет символ текста без экранирования
+//
+// char rune - символ
+//
+func (self *nodeList) addRune(char rune) {
+	self.text.WriteRune(char)
+}
+
+//
+// Добавляет узлы, текстовые узлы объединяются с соседним текстом, кроме текста автоматической ссылки
+//
+// nodes []*Node - узлы
+//
+func (self *nodeList) add(nodes ...*Node) {
+	for _, node := range nodes {
+		if node.Kind == NodeText && !node.autoLink {
+			self.text.WriteString(node.Text)
+			continue
+		}
+		self.flush()
+		self.nodes = append(self.nodes, node)
+	}
+}
+
+//
+// Переносит накопленный текст в список отдельным узлом
+//
+func (self *nodeList) flush() {
+	if self.text.Len() == 0 {
+		return
+	}
+	self.nodes = append(self.nodes, &Node{Kind: NodeText, Text: self.text.String()})
+	self.text.Reset()
+}
+
+//
+// Возвращает собранные узлы
+//
+func (self *nodeList) result() []*Node {
+	self.flush()
+	if self.nodes == nil {
+		return []*Node{}
+	}
+	return self.nodes
+}
+
+//
+// Удаляет пробелы в начале и в конце узлов верхнего уровня
+//
+// nodes []*Node - узлы
+//
+func trimNodes(nodes []*Node) []*Node {
+	for len(nodes) > 0 && nodes[0].Kind != NodeElement {
+		nodes[0].Text = strings.TrimLeftFunc(nodes[0].Text, unicode.IsSpace)
+		if nodes[0].Text != "" {
+			break
+		}
+		nodes = nodes[1:]
+	}
+
+	for len(nodes) > 0 && nodes[len(nodes)-1].Kind != NodeElement {
+		last := nodes[len(nodes)-1]
+		last.Text = strings.TrimRightFunc(last.Text, unicode.IsSpace)
+		if last.Text != "" {
+			break
+		}
+		nodes = nodes[:len(nodes)-1]
+	}
+
+	return nodes
+}
+
+//
+// Возвращает глубокую копию узлов
+//
+// nodes []*Node - узлы
+//
+func cloneNodes(nodes []*Node) []*Node {
+	if nodes == nil {
+		return nil
+	}
+
+	result := make([]*Node, len(nodes))
+	for i, node := range nodes {
+		clone := *node
+		clone.Attrs = append([]Attr(nil), node.Attrs...)
+		clone.Children = cloneNodes(node.Children)
+		result[i] = &clone
+	}
+
+	return result
+}
+
+//
+// Возвращает текст узлов без тегов
+//
+// nodes []*Node - узлы
+//
+func nodesText(nodes []*Node) string {
+	buff := strings.Builder{}
+	Walk(nodes, func(node *Node) bool {
+		if node.Kind == NodeText {
+			buff.WriteString(node.Text)
+		}
+		return true
+	})
+	return buff.String()
+}
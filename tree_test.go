@@ -0,0 +1,116 @@
+package qevix_test
+
+import (
+	"qevix"
+	"testing"
+)
+
+var treeQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgAllowTags([]string{"a", "b", "p", "br", "img", "ul", "li"})
+	cfg.CfgSetTagShort([]string{"br", "img"})
+	cfg.CfgSetTagBlockType([]string{"p", "ul"})
+	cfg.CfgAllowTagParams("a", []string{"href", "title"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+	cfg.CfgAllowTagParams("img", []string{"src", "alt"})
+	cfg.CfgAllowTagParamValue("img", "src", "#link")
+	cfg.CfgSetTagChilds("ul", []string{"li"})
+	cfg.CfgSetTagParentOnly([]string{"ul"})
+	cfg.CfgSetTagChildOnly([]string{"li"})
+})
+
+func TestTreeN1(t *testing.T) {
+	texts := []string{
+		`<p>Текст <b>жирный</b> и <a title='a "b" &amp; c' href="http://dighub.ru/?a=1&b=2">ссылка</a></p>`,
+		"строка 1\nстрока 2 <img src=\"http://dighub.ru/i.png\" alt=\"<x>\">",
+		"<ul><li>a</li><li>b <i>c</i></li></ul>\n\nhttp://dighub.ru/",
+		`5 > 3 & 2 < 4 "кавычки"`,
+		`поиск http://yandex.ru/search/?lr=2&text=golang&amp;x и www.dighub.ru/?a=1&b=2`,
+	}
+
+	for _, mode := range []bool{false, true} {
+		cfg := treeQvx.Compile()
+		if mode {
+			c := qevix.New()
+			c.CfgApplySpec(treeQvx.Spec())
+			c.CfgSetXHTMLMode(true)
+			c.CfgSetEOL("\r\n")
+			cfg = c.Compile()
+		}
+
+		for _, text := range texts {
+			expect, _ := cfg.Parse(text)
+			nodes, _ := cfg.ParseTree(text)
+
+			if result := cfg.Render(nodes); result != expect {
+				t.Errorf("Expect result to equal in func TestTreeN1(t *testing.T).\n%s\n%s", expect, result)
+			}
+		}
+	}
+}
+
+func TestTreeN2(t *testing.T) {
+	nodes, _ := treeQvx.ParseTree(`<p>a <a href="http://dighub.ru/" title="x">b</a> <b>c <a href="/d">d</a></b></p>`)
+
+	if len(nodes) != 1 || nodes[0].Kind != qevix.NodeElement || nodes[0].Tag != "p" {
+		t.Fatalf("Expect tree to equal in func TestTreeN2(t *testing.T).\n%v", nodes)
+	}
+
+	links := []string{}
+	qevix.Walk(nodes, func(node *qevix.Node) bool {
+		if node.Kind == qevix.NodeElement && node.Tag == "a" {
+			href, _ := node.Attr("href")
+			links = append(links, href)
+			node.SetAttr("rel", "nofollow")
+		}
+		return node.Tag != "b"
+	})
+
+	if len(links) != 1 || links[0] != "http://dighub.ru/" {
+		t.Errorf("Expect links to equal in func TestTreeN2(t *testing.T).\n%v", links)
+	}
+
+	expect := `<p>a <a href="http://dighub.ru/" title="x" rel="nofollow">b</a> <b>c <a href="/d">d</a></b></p>`
+
+	if result := treeQvx.Render(nodes); result != expect {
+		t.Errorf("Expect result to equal in func TestTreeN2(t *testing.T).\n%s", result)
+	}
+
+	text := nodes[0].Children[0]
+	if text.Kind != qevix.NodeText || text.Text != "a " {
+		t.Errorf("Expect text to equal in func TestTreeN2(t *testing.T).\n%v", text)
+	}
+}
+
+func TestTreeN3(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"span", "b", "p"})
+	cfg.CfgSetTagBlockType([]string{"p"})
+	cfg.CfgAllowTagParams("span", []string{"data-x"})
+	cfg.CfgSetTagBuildCallback("b", func(tag string, params map[string]string, content string) string {
+		return "<strong data-tag=\"" + tag + "\">" + content + "</strong>"
+	})
+
+	texts := []string{
+		`<span data-x="a>b">c</span>`,
+		`<p>a <b>b <span data-x='"x"'>c</span></b></p>`,
+	}
+
+	for _, text := range texts {
+		expect, _ := cfg.Parse(text)
+		nodes, _ := cfg.ParseTree(text)
+
+		if result := cfg.Render(nodes); result != expect {
+			t.Errorf("Expect result to equal in func TestTreeN3(t *testing.T).\n%s\n%s", expect, result)
+		}
+	}
+
+	nodes, _ := cfg.ParseTree(`<span data-x="a>b">c</span>`)
+	if value, _ := nodes[0].Attr("data-x"); len(nodes) != 1 || value != "a>b" || nodes[0].Children[0].Text != "c" {
+		t.Errorf("Expect tree to equal in func TestTreeN3(t *testing.T).\n%v", nodes)
+	}
+
+	nodes, _ = cfg.ParseTree(`<p>a <b>b</b></p>`)
+	if raw := nodes[0].Children[1]; raw.Kind != qevix.NodeRaw || raw.Tag != "b" || raw.Text != `<strong data-tag="b">b</strong>` {
+		t.Errorf("Expect tree to equal in func TestTreeN3(t *testing.T).\n%v", nodes[0].Children)
+	}
+}
@@ -0,0 +1,649 @@
+package qevix
+
+import (
+	"errors"
+	"html"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//
+// Проверка значения параметра тега, скомпилированная из шаблона при конфигурации.
+// Возвращает значение, возможно приведенное к допустимому виду, и признак допустимости.
+//
+type valueMatcher func(r *rules, value string) (string, bool)
+
+var (
+	intValueRx        = regexp.MustCompile(`^[0-9]+$`)
+	signedIntValueRx  = regexp.MustCompile(`^-?[0-9]+$`)
+	floatValueRx      = regexp.MustCompile(`^-?([0-9]+(\.[0-9]+)?|\.[0-9]+)$`)
+	percentValueRx    = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?|\.[0-9]+)%$`)
+	lengthValueRx     = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?|\.[0-9]+)(px|em|rem|%)?$`)
+	linkFirstCharRx   = regexp.MustCompile(`^[\pL\pN]`)
+	regexpTemplateRx  = regexp.MustCompile(`^#regexp\((.*?)\)$`)
+	linkTemplateRx    = regexp.MustCompile(`^#link\(([^()]*)\)$`)
+	classTemplateRx   = regexp.MustCompile(`^#class\(([^()]*)\)$`)
+	classPatternRx    = regexp.MustCompile(`^[_a-zA-Z0-9\-]+\*?$`)
+	classTokenRx      = regexp.MustCompile(`^-?[_a-zA-Z][_a-zA-Z0-9\-]*$`)
+	telLinkRx         = regexp.MustCompile(`^\+?[0-9\-.()]*[0-9][0-9\-.()]*(;[a-z\-]+=[0-9a-zA-Z\-.()+]+)*$`)
+	xmppLinkRx        = regexp.MustCompile(`^[^@/?#\s]+@[\pL\pN.\-]+(/[^?#\s]*)?(\?[^#\s]*)?$`)
+	validatorNameRx   = regexp.MustCompile(`^#[a-z][a-z0-9_\-]*$`)
+	rangeTemplateRx   = regexp.MustCompile(`^#(int|float|percent|length)\(\s*([^,\s]*)\s*,\s*([^,\s]*)\s*\)$`)
+	errRegexpTemplate = errors.New("неверный шаблон #regexp(...)")
+	errRangeTemplate  = errors.New("неверный диапазон шаблона, ожидается #шаблон(мин,макс)")
+	errLinkTemplate   = errors.New("неверный шаблон #link(...), ожидается список протоколов через запятую")
+	errClassTemplate  = errors.New("неверный шаблон #class(...), ожидается список классов или префиксов вида 'language-*' через запятую")
+)
+
+//
+// Имена встроенных шаблонов, которые нельзя переопределить
+//
+var builtinTemplates = map[string]bool{
+	"#str": true, "#int": true, "#float": true, "#percent": true, "#length": true, "#link": true, "#regexp": true,
+	"#style": true, "#css-color": true, "#class": true,
+}
+
+//
+// Числовые шаблоны: регулярное выражение для значения и единицы измерения, отбрасываемые перед сравнением с диапазоном
+//
+var numberTemplates = map[string]struct {
+	rx    *regexp.Regexp
+	units []string
+}{
+	"int":     {intValueRx, nil},
+	"float":   {floatValueRx, nil},
+	"percent": {percentValueRx, []string{"%"}},
+	"length":  {lengthValueRx, []string{"px", "rem", "em", "%"}},
+}
+
+//
+// Компилирует шаблон значения параметра
+//
+// template string - шаблон #str, #int, #float, #percent, #length, #link, #link(...), #class, #class(...), #style, #css-color, #regexp(...),
+// именованный шаблон или точное значение
+//
+func compileParamValue(template string) (valueMatcher, error) {
+	switch {
+	case template == "#str":
+		return matchStrValue, nil
+	case template == "#int", template == "#float", template == "#percent", template == "#length":
+		return compileNumberValue(template[1:], "", "")
+	case rangeTemplateRx.MatchString(template):
+		mc := rangeTemplateRx.FindStringSubmatch(template)
+		return compileNumberValue(mc[1], mc[2], mc[3])
+	case strings.HasPrefix(template, "#int("), strings.HasPrefix(template, "#float("),
+		strings.HasPrefix(template, "#percent("), strings.HasPrefix(template, "#length("):
+		return nil, errRangeTemplate
+	case template == "#link":
+		return matchLinkValue, nil
+	case template == "#class":
+		return compileClassValue(nil), nil
+	case strings.HasPrefix(template, "#class("):
+		mc := classTemplateRx.FindStringSubmatch(template)
+		if mc == nil {
+			return nil, errClassTemplate
+		}
+
+		patterns := strings.Split(mc[1], ",")
+		for i, pattern := range patterns {
+			patterns[i] = strings.TrimSpace(pattern)
+			if !classPatternRx.MatchString(patterns[i]) {
+				return nil, errClassTemplate
+			}
+		}
+
+		return compileClassValue(patterns), nil
+	case template == "#style":
+		return matchStyleValue, nil
+	case template == "#css-color":
+		return matchCSSColorValue, nil
+	case strings.HasPrefix(template, "#link("):
+		mc := linkTemplateRx.FindStringSubmatch(template)
+		if mc == nil {
+			return nil, errLinkTemplate
+		}
+
+		protocols := strings.Split(mc[1], ",")
+		for i, protocol := range protocols {
+			protocols[i] = strings.TrimSpace(protocol)
+			if !protocolRx.MatchString(protocols[i]) {
+				return nil, errLinkTemplate
+			}
+		}
+
+		allow := compileLinkProtocols(protocols)
+
+		return func(r *rules, value string) (string, bool) {
+			return matchLink(r, allow, value)
+		}, nil
+	case strings.HasPrefix(template, "#regexp"):
+		mc := regexpTemplateRx.FindStringSubmatch(template)
+		if mc == nil {
+			return nil, errRegexpTemplate
+		}
+
+		rx, err := regexp.Compile(mc[1])
+		if err != nil {
+			return nil, err
+		}
+
+		return func(r *rules, value string) (string, bool) {
+			return value, rx.MatchString(value)
+		}, nil
+	case validatorNameRx.MatchString(template):
+		return func(r *rules, value string) (string, bool) {
+			if validator, ok := r.paramValidators[template]; ok {
+				return validator(value)
+			}
+			return value, value == template
+		}, nil
+	}
+
+	return func(r *rules, value string) (string, bool) {
+		return value, value == template
+	}, nil
+}
+
+//
+// Строит множество разрешенных схем ссылок
+//
+// protocols []string - список протоколов
+//
+func compileLinkProtocols(protocols []string) map[string]bool {
+	set := make(map[string]bool, len(protocols))
+	for _, protocol := range protocols {
+		set[protocol] = true
+	}
+	return set
+}
+
+//
+// Шаблон #str, любая строка
+//
+func matchStrValue(r *rules, value string) (string, bool) {
+	return value, true
+}
+
+//
+// Компилирует шаблон #class(...). Оставляет классы, совпадающие с одним из шаблонов
+// (точное имя или префикс с "*" в конце), удаляет повторы и добавляет префикс классов.
+// Без шаблонов допускается любой класс с корректным именем.
+//
+// patterns []string - разрешенные классы и префиксы
+//
+func compileClassValue(patterns []string) valueMatcher {
+	exact := make(map[string]bool, len(patterns))
+	prefixes := []string{}
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			prefixes = append(prefixes, pattern[:len(pattern)-1])
+		} else {
+			exact[pattern] = true
+		}
+	}
+
+	allowed := func(token string) bool {
+		if patterns == nil || exact[token] {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(token, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *rules, value string) (string, bool) {
+		tokens := []string{}
+		seen := make(map[string]bool)
+		for _, token := range strings.Fields(value) {
+			// Префикс уже добавлен, например при повторной обработке документа
+			if r.classPrefix != "" {
+				token = strings.TrimPrefix(token, r.classPrefix)
+			}
+			if seen[token] || !classTokenRx.MatchString(token) || !allowed(token) {
+				continue
+			}
+			seen[token] = true
+			tokens = append(tokens, r.classPrefix+token)
+		}
+
+		if len(tokens) == 0 {
+			return value, false
+		}
+
+		return strings.Join(tokens, " "), true
+	}
+}
+
+//
+// Компилирует числовой шаблон с необязательным диапазоном.
+// Пустая граница диапазона означает отсутствие ограничения с этой стороны.
+//
+// kind string - int, float, percent или length
+// min string - нижняя граница
+// max string - верхняя граница
+//
+func compileNumberValue(kind string, min string, max string) (valueMatcher, error) {
+	tpl := numberTemplates[kind]
+	rx := tpl.rx
+
+	lo, hi := 0.0, 0.0
+	hasLo, hasHi := min != "", max != ""
+
+	for _, bound := range []struct {
+		str string
+		val *float64
+	}{{min, &lo}, {max, &hi}} {
+		if bound.str == "" {
+			continue
+		}
+
+		var err error
+		if kind == "int" {
+			var n int64
+			n, err = strconv.ParseInt(bound.str, 10, 64)
+			*bound.val = float64(n)
+		} else {
+			*bound.val, err = strconv.ParseFloat(bound.str, 64)
+		}
+
+		if err != nil {
+			return nil, errRangeTemplate
+		}
+	}
+
+	if hasLo && hasHi && lo > hi {
+		return nil, errRangeTemplate
+	}
+
+	// Отрицательные целые допустимы, только если их разрешает диапазон
+	if kind == "int" && hasLo && lo < 0 {
+		rx = signedIntValueRx
+	}
+
+	return func(r *rules, value string) (string, bool) {
+		if !rx.MatchString(value) {
+			return value, false
+		}
+
+		if !hasLo && !hasHi {
+			return value, true
+		}
+
+		num := value
+		for _, unit := range tpl.units {
+			if strings.HasSuffix(num, unit) {
+				num = num[:len(num)-len(unit)]
+				break
+			}
+		}
+
+		n, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return value, false
+		}
+
+		return value, (!hasLo || n >= lo) && (!hasHi || n <= hi)
+	}, nil
+}
+
+//
+// Вид ссылки
+//
+type linkKind int
+
+const (
+	linkInvalid          linkKind = iota // Недопустимая ссылка
+	linkAbsolute                         // Ссылка со схемой: http://example.com, mailto:user@example.com
+	linkProtocolRelative                 // Ссылка без схемы, но с хостом: //example.com
+	linkRelative                         // Относительная ссылка: /path, ./path, ?query
+	linkFragment                         // Якорь: #name
+)
+
+//
+// Схемы, у которых обязательно должен быть хост
+//
+var hostSchemes = map[string]bool{"http": true, "https": true, "ftp": true}
+
+//
+// Встроенные проверки ссылок с непрозрачными схемами.
+// Функция получает часть ссылки после "схема:".
+//
+var linkSchemeRules = map[string]func(string) bool{
+	"mailto": isMailtoLink,
+	"tel":    telLinkRx.MatchString,
+	"xmpp":   xmppLinkRx.MatchString,
+}
+
+//
+// Шаблон #link, ссылка
+//
+// Значение разбирается как URL: декодируются HTML-сущности, удаляются управляющие символы,
+// схема приводится к нижнему регистру и проверяется по списку разрешенных схем.
+// Ссылка без схемы и без "/" в начале считается адресом сайта и дополняется "http://".
+//
+func matchLinkValue(r *rules, value string) (string, bool) {
+	return matchLink(r, r.linkProtocolSet, value)
+}
+
+//
+// Проверяет ссылку по списку разрешенных схем
+//
+// allow map[string]bool - разрешенные схемы
+// value string - значение параметра
+//
+func matchLink(r *rules, allow map[string]bool, value string) (string, bool) {
+	link, kind := parseLink(r, allow, value)
+	if kind == linkInvalid {
+		return value, false
+	}
+
+	// Значение не изменилось, оставляем его в исходном виде
+	if link == html.UnescapeString(value) {
+		return value, true
+	}
+
+	return escapeValue(r, link), true
+}
+
+//
+// Разбирает и нормализует ссылку, возвращает ссылку в декодированном виде и ее вид
+//
+// allow map[string]bool - разрешенные схемы
+// value string - значение параметра
+//
+func parseLink(r *rules, allow map[string]bool, value string) (string, linkKind) {
+	link := decodeLink(value)
+	if link == "" {
+		return "", linkInvalid
+	}
+
+	kind := linkAbsolute
+
+	switch {
+	case link[0] == '#':
+		kind = linkFragment
+	case strings.HasPrefix(link, "//"):
+		if !allow["http"] && !allow["https"] {
+			return "", linkInvalid
+		}
+		kind = linkProtocolRelative
+	case link[0] == '/' || link[0] == '?' || strings.HasPrefix(link, "./") || strings.HasPrefix(link, "../"):
+		kind = linkRelative
+	default:
+		if scheme, rest, ok := splitLinkScheme(link); ok {
+			if !allow[scheme] {
+				return "", linkInvalid
+			}
+			link = scheme + ":" + rest
+
+			if validator, ok := r.linkProtocolValidators[scheme]; ok {
+				if link, ok = validator(link); !ok {
+					return "", linkInvalid
+				}
+			} else if rule, ok := linkSchemeRules[scheme]; ok && !rule(rest) {
+				return "", linkInvalid
+			} else if !hostSchemes[scheme] && rest == "" {
+				return "", linkInvalid
+			}
+		} else {
+			if !linkFirstCharRx.MatchString(link) || !allow["http"] {
+				return "", linkInvalid
+			}
+			link = "http://" + link
+		}
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", linkInvalid
+	}
+
+	if (kind == linkProtocolRelative || hostSchemes[u.Scheme]) && u.Host == "" {
+		return "", linkInvalid
+	}
+
+	return link, kind
+}
+
+//
+// Декодирует значение параметра: экранирование парсера, HTML-сущности автора,
+// управляющие символы и обратные слеши, которые браузеры считают прямыми
+//
+// value string - значение параметра
+//
+func decodeLink(value string) string {
+	link := html.UnescapeString(html.UnescapeString(value))
+
+	link = strings.Map(func(char rune) rune {
+		switch {
+		case char < 0x20 || char == 0x7f:
+			return -1
+		case char == '\\':
+			return '/'
+		}
+		return char
+	}, link)
+
+	return strings.TrimSpace(link)
+}
+
+//
+// Выделяет схему ссылки. Имя хоста с портом (example.com:8080) схемой не считается.
+//
+// link string - ссылка
+//
+func splitLinkScheme(link string) (string, string, bool) {
+	i := strings.IndexAny(link, ":/?#")
+	if i <= 0 || link[i] != ':' {
+		return "", "", false
+	}
+
+	scheme := strings.ToLower(link[:i])
+	if !protocolRx.MatchString(scheme) {
+		return "", "", false
+	}
+
+	rest := link[i+1:]
+	if strings.Contains(scheme, ".") && rest != "" && rest[0] >= '0' && rest[0] <= '9' {
+		return "", "", false
+	}
+
+	return scheme, rest, true
+}
+
+//
+// Разрешает относительную ссылку относительно базового адреса, вызывает callback-функцию перезаписи,
+// проверяет домен итоговой ссылки и пропускает внешние изображения через прокси. Возвращает ссылку, признак внешней ссылки
+// и причину отклонения, если ссылка не допустима.
+//
+// tag string - тег
+// param string - параметр
+// value string - значение параметра, прошедшее проверку шаблоном
+//
+func (self *rules) makeLink(tag string, param string, value string) (string, bool, Reason) {
+	// Ссылки-якоря указывают на идентификаторы с префиксом
+	if self.idPrefix != "" {
+		if link := decodeLink(value); strings.HasPrefix(link, "#") && idRx.MatchString(link[1:]) && !strings.HasPrefix(link[1:], self.idPrefix) {
+			value = escapeValue(self, "#"+self.idPrefix+link[1:])
+		}
+	}
+
+	link := decodeLink(value)
+	orig := link
+
+	if self.linkBaseURL != nil && !strings.HasPrefix(link, "#") {
+		if u, err := url.Parse(link); err == nil && !u.IsAbs() {
+			link = self.linkBaseURL.ResolveReference(u).String()
+		}
+	}
+
+	external, reason := self.checkLinkHost(tag, param, link)
+	if reason != 0 {
+		return value, false, reason
+	}
+
+	// Подписанная прокси ссылка уже перезаписана при первой сборке, например ссылка из модели документа
+	isSigned := self.imageProxy != nil && self.tagParamImage[tag][param] && self.imageProxy.signed(link)
+
+	if self.linkRewriteCallback != nil && !isSigned {
+		if link = self.linkRewriteCallback(tag, param, link, external); link == "" {
+			return value, false, ReasonInvalidValue
+		}
+
+		// Перезаписанная ссылка может указывать на другой домен
+		if external, reason = self.checkLinkHost(tag, param, link); reason != 0 {
+			return value, false, reason
+		}
+	}
+
+	// Прокси применяется последним, к итоговой ссылке
+	if self.imageProxy != nil && external && self.tagParamImage[tag][param] && !isSigned {
+		if absolute, ok := proxyLink(link); ok {
+			link = self.imageProxy.sign(absolute)
+		}
+	}
+
+	if link == orig {
+		return value, external, 0
+	}
+
+	return escapeValue(self, link), external, 0
+}
+
+//
+// Проверяет домен ссылки по спискам запрещенных и разрешенных доменов. Возвращает признак внешней ссылки
+// и причину отклонения, если домен не допустим. Ссылка без домена считается внутренней.
+//
+// tag string - тег
+// param string - параметр
+// link string - ссылка без экранирования
+//
+func (self *rules) checkLinkHost(tag string, param string, link string) (bool, Reason) {
+	host := linkHost(link)
+	if host == "" {
+		return false, 0
+	}
+
+	hosts, restricted := self.tagParamHosts[tag][param]
+	if matchHost(host, self.linkHostBlock) || (restricted && !matchHost(host, hosts)) {
+		return false, ReasonHostNotAllowed
+	}
+
+	return !matchHost(host, self.linkHostInternal), 0
+}
+
+//
+// Проверяет, есть ли среди шаблонов параметра шаблон ссылки
+//
+// templates []string - шаблоны значений параметра
+//
+func isLinkTemplates(templates []string) bool {
+	for _, template := range templates {
+		if template == "#link" || strings.HasPrefix(template, "#link(") {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Возвращает домен ссылки в нижнем регистре или пустую строку, если домена нет
+//
+// value string - значение параметра
+//
+func linkHost(value string) string {
+	link := decodeLink(value)
+	if strings.HasPrefix(link, "//") {
+		link = "http:" + link
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+//
+// Проверяет, совпадает ли домен с одним из доменов списка или является его поддоменом
+//
+// host string - домен
+// hosts []string - список доменов
+//
+func matchHost(host string, hosts []string) bool {
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Проверяет адреса ссылки mailto:
+//
+// rest string - часть ссылки после "mailto:"
+//
+func isMailtoLink(rest string) bool {
+	if i := strings.IndexByte(rest, '?'); i != -1 {
+		rest = rest[:i]
+	}
+
+	to, err := url.PathUnescape(rest)
+	if err != nil || to == "" {
+		return false
+	}
+
+	for _, address := range strings.Split(to, ",") {
+		address = strings.TrimSpace(address)
+		parsed, err := mail.ParseAddress(address)
+		if err != nil || parsed.Address != address {
+			return false
+		}
+	}
+
+	return true
+}
+
+//
+// Экранирует значение для вывода в параметре тега
+//
+// value string - значение
+//
+func escapeValue(r *rules, value string) string {
+	buff := strings.Builder{}
+	for _, char := range value {
+		if entity, ok := r.entities[char]; ok {
+			buff.WriteString(entity)
+		} else {
+			buff.WriteRune(char)
+		}
+	}
+	return buff.String()
+}
+
+//
+// Раскрывает экранирование значения, обратное escapeValue
+//
+// value string - экранированное значение
+//
+func unescapeValue(r *rules, value string) string {
+	if !strings.Contains(value, "&") {
+		return value
+	}
+
+	for char, entity := range r.entities {
+		if char != '&' {
+			value = strings.Replace(value, entity, string(char), -1)
+		}
+	}
+
+	return strings.Replace(value, r.entities['&'], "&", -1)
+}
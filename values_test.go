@@ -0,0 +1,551 @@
+package qevix_test
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"qevix"
+	"strings"
+	"testing"
+)
+
+var valuesQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgSetXHTMLMode(true)
+	cfg.CfgAllowTags([]string{"img", "td", "p"})
+	cfg.CfgSetTagShort([]string{"img"})
+	cfg.CfgAllowTagParams("img", []string{"src", "width", "height", "vspace"})
+	cfg.CfgAllowTagParamValue("img", "width", "#int")
+	cfg.CfgAllowTagParamValue("img", "height", "#int(1,1080)")
+	cfg.CfgAllowTagParamValue("img", "vspace", "#int(-10,10)")
+	cfg.CfgAllowTagParams("td", []string{"width", "height"})
+	cfg.CfgAllowTagParamValue("td", "width", "#length(,800)")
+	cfg.CfgAllowTagParamValue("td", "height", "#percent")
+	cfg.CfgAllowTagParams("p", []string{"data-opacity"})
+	cfg.CfgAllowTagParamValue("p", "data-opacity", "#float(0,1)")
+})
+
+func TestValuesN1(t *testing.T) {
+	text := `<img src="a.png" width="640" height="720" vspace="-5"/><img src="b.png" width="wide" height="2000" vspace="-11"/>`
+
+	expect := `<img src="a.png" width="640" height="720" vspace="-5"/><img src="b.png"/>`
+
+	result, errs := valuesQvx.Parse(text)
+
+	if result != expect || len(errs) != 3 {
+		t.Errorf("Expect result to equal in func TestValuesN1(t *testing.T).\n%s\n%v", result, errs)
+	}
+}
+
+func TestValuesN2(t *testing.T) {
+	text := `<td width="120px" height="50%">a</td><td width="1.5em" height="50">b</td><td width="900px" height="x%">c</td>`
+
+	expect := `<td width="120px" height="50%">a</td><td width="1.5em">b</td><td>c</td>`
+
+	result, errs := valuesQvx.Parse(text)
+
+	if result != expect || len(errs) != 3 {
+		t.Errorf("Expect result to equal in func TestValuesN2(t *testing.T).\n%s\n%v", result, errs)
+	}
+}
+
+func TestValuesN3(t *testing.T) {
+	text := `<p data-opacity="0.5">a</p><p data-opacity="1.5">b</p><p data-opacity="-.5">c</p>`
+
+	expect := `<p data-opacity="0.5">a</p><p>b</p><p>c</p>`
+
+	result, errs := valuesQvx.Parse(text)
+
+	if result != expect || len(errs) != 2 {
+		t.Errorf("Expect result to equal in func TestValuesN3(t *testing.T).\n%s\n%v", result, errs)
+	}
+}
+
+func TestValuesN4(t *testing.T) {
+	templates := []string{"#int(10,1)", "#int(a,b)", "#int(1.5,2)", "#float(0;1)", "#percent(0,"}
+
+	for _, template := range templates {
+		cfg := qevix.New()
+		cfg.CfgAllowTags([]string{"img"})
+		cfg.CfgAllowTagParams("img", []string{"width"})
+
+		if err := cfg.CfgAllowTagParamValue("img", "width", template); err == nil {
+			t.Errorf("Expect error in func TestValuesN4(t *testing.T).\n%s", template)
+		}
+	}
+}
+
+func TestValuesN5(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"span"})
+	cfg.CfgAllowTagParams("span", []string{"lang", "title"})
+	cfg.CfgAllowTagParamValue("span", "lang", "#lang")
+	cfg.CfgAllowTagParamValue("span", "title", []string{"#upper", "#top"})
+	cfg.CfgSetParamValidator("#lang", func(value string) (string, bool) {
+		value = strings.ToLower(value)
+		return value, value == "ru" || value == "en"
+	})
+	cfg.CfgSetParamValidator("#upper", func(value string) (string, bool) {
+		return strings.ToUpper(value), true
+	})
+
+	var cfgErr *qevix.ConfigError
+	if err := cfg.Validate(); !errors.As(err, &cfgErr) || cfgErr.Param != "title" || !strings.Contains(cfgErr.Msg, "#top") {
+		t.Fatalf("Expect error for #top in func TestValuesN5(t *testing.T).\n%v", err)
+	}
+
+	text := `<span lang="RU" title="a">a</span><span lang="de">b</span>`
+
+	expect := `<span lang="ru" title="A">a</span><span>b</span>`
+
+	result, errs := cfg.Compile().Parse(text)
+
+	if result != expect || len(errs) != 1 {
+		t.Errorf("Expect result to equal in func TestValuesN5(t *testing.T).\n%s\n%v", result, errs)
+	}
+}
+
+func TestValuesN6(t *testing.T) {
+	cfg := qevix.New()
+
+	names := []string{"color", "#Color", "#int", "#link"}
+
+	for _, name := range names {
+		if err := cfg.CfgSetParamValidator(name, func(value string) (string, bool) { return value, true }); err == nil {
+			t.Errorf("Expect error in func TestValuesN6(t *testing.T).\n%s", name)
+		}
+	}
+
+	if err := cfg.CfgSetParamValidator("#color", nil); err == nil {
+		t.Errorf("Expect error in func TestValuesN6(t *testing.T).\n%s", "nil")
+	}
+
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expect error in func TestValuesN6(t *testing.T).\n%s", "Validate")
+	}
+}
+
+func TestValuesN7(t *testing.T) {
+	cfg, err := qevix.LoadConfig(strings.NewReader(`{"tags": {"span": {"params": ["style"], "values": {"style": ["#color"]}}}}`))
+	if err != nil {
+		t.Fatalf("Expect no error in func TestValuesN7(t *testing.T).\n%v", err)
+	}
+
+	cfg.CfgSetParamValidator("#color", func(value string) (string, bool) {
+		return value, value == "red"
+	})
+
+	result, _ := cfg.Compile().Parse(`<span style="red">a</span><span style="blue">b</span>`)
+
+	expect := `<span style="red">a</span><span>b</span>`
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestValuesN7(t *testing.T).\n%s", result)
+	}
+}
+
+var linkQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgAllowTags([]string{"a"})
+	cfg.CfgAllowTagParams("a", []string{"href"})
+	cfg.CfgSetTagParamsRequired("a", []string{"href"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+	cfg.CfgSetLinkProtocolAllow([]string{"http", "https"})
+})
+
+func TestValuesN8(t *testing.T) {
+	links := map[string]string{
+		`http://dighub.ru/`:                  `<a href="http://dighub.ru/">a</a>`,
+		`HTTPS://dighub.ru/`:                 `<a href="https://dighub.ru/">a</a>`,
+		` dighub.ru/page `:                   `<a href="http://dighub.ru/page">a</a>`,
+		`dighub.ru:8080/page`:                `<a href="http://dighub.ru:8080/page">a</a>`,
+		`/page?id=1`:                         `<a href="/page?id=1">a</a>`,
+		`../page`:                            `<a href="../page">a</a>`,
+		`#top`:                               `<a href="#top">a</a>`,
+		`//dighub.ru/page`:                   `<a href="//dighub.ru/page">a</a>`,
+		`/\dighub.ru/page`:                   `<a href="//dighub.ru/page">a</a>`,
+		`/page?a=1&amp;b=2`:                  `<a href="/page?a=1&#38;b=2">a</a>`,
+		`javascript:alert(1)`:                `a`,
+		`JavaScript:alert(1)`:                `a`,
+		`java&#x09;script:alert(1)`:          `a`,
+		`javascript&#58;alert(1)`:            `a`,
+		"\x01javascript:alert(1)":            `a`,
+		`mailto:user@dighub.ru`:              `a`,
+		`ftp://dighub.ru/`:                   `a`,
+		`http:dighub.ru`:                     `a`,
+		`//`:                                 `a`,
+		`data:text/html;base64,PHNjcmlwdD4=`: `a`,
+	}
+
+	for link, expect := range links {
+		result, _ := linkQvx.Parse(`<a href="` + link + `">a</a>`)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestValuesN8(t *testing.T).\n%q: %s", link, result)
+		}
+	}
+}
+
+func TestValuesN9(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"a"})
+	cfg.CfgAllowTagParams("a", []string{"href", "data-call"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+	cfg.CfgAllowTagParamValue("a", "data-call", "#link(tel, myapp)")
+	cfg.CfgSetLinkProtocolAllow([]string{"http", "https", "mailto", "xmpp", "myapp"})
+	cfg.CfgSetLinkProtocolValidator("myapp", func(link string) (string, bool) {
+		return link, strings.HasPrefix(link, "myapp:open/")
+	})
+
+	links := map[string]string{
+		`mailto:user@dighub.ru`:                `<a href="mailto:user@dighub.ru">a</a>`,
+		`MAILTO:user@dighub.ru?subject=Привет`: `<a href="mailto:user@dighub.ru?subject=Привет">a</a>`,
+		`mailto:a@dighub.ru,b@dighub.ru`:       `<a href="mailto:a@dighub.ru,b@dighub.ru">a</a>`,
+		`mailto:not-an-address`:                `<a>a</a>`,
+		`mailto:`:                              `<a>a</a>`,
+		`xmpp:romeo@dighub.ru`:                 `<a href="xmpp:romeo@dighub.ru">a</a>`,
+		`xmpp:dighub.ru`:                       `<a>a</a>`,
+		`myapp:open/42`:                        `<a href="myapp:open/42">a</a>`,
+		`myapp:delete/42`:                      `<a>a</a>`,
+		`tel:+7-900-000-00-00`:                 `<a>a</a>`,
+	}
+
+	for link, expect := range links {
+		result, _ := cfg.Parse(`<a href="` + link + `">a</a>`)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestValuesN9(t *testing.T).\n%q: %s", link, result)
+		}
+	}
+
+	calls := map[string]string{
+		`tel:+7-900-000-00-00`:  `<a data-call="tel:+7-900-000-00-00">a</a>`,
+		`tel:+7 900`:            `<a>a</a>`,
+		`tel:ext`:               `<a>a</a>`,
+		`myapp:open/1`:          `<a data-call="myapp:open/1">a</a>`,
+		`mailto:user@dighub.ru`: `<a>a</a>`,
+		`http://dighub.ru`:      `<a>a</a>`,
+	}
+
+	for link, expect := range calls {
+		result, _ := cfg.Parse(`<a data-call="` + link + `">a</a>`)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestValuesN9(t *testing.T).\n%q: %s", link, result)
+		}
+	}
+}
+
+func TestValuesN10(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"a"})
+	cfg.CfgAllowTagParams("a", []string{"href"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+	cfg.CfgSetLinkProtocolAllow([]string{"https", "mailto"})
+
+	text := `пишите mailto:user@dighub.ru или https://dighub.ru, но не http://dighub.ru и не tel:+79000000000`
+
+	expect := `пишите <a href="mailto:user@dighub.ru">mailto:user@dighub.ru</a> или <a href="https://dighub.ru">https://dighub.ru</a>, но не http://dighub.ru и не tel:+79000000000`
+
+	result, _ := cfg.Parse(text)
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestValuesN10(t *testing.T).\n%s", result)
+	}
+}
+
+func TestValuesN11(t *testing.T) {
+	templates := []string{"#link()", "#link(http,)", "#link(HTTP)", "#link(http"}
+
+	for _, template := range templates {
+		cfg := qevix.New()
+		cfg.CfgAllowTags([]string{"a"})
+		cfg.CfgAllowTagParams("a", []string{"href"})
+
+		if err := cfg.CfgAllowTagParamValue("a", "href", template); err == nil {
+			t.Errorf("Expect error in func TestValuesN11(t *testing.T).\n%s", template)
+		}
+	}
+
+	if err := qevix.New().CfgSetLinkProtocolValidator("my app", func(link string) (string, bool) { return link, true }); err == nil {
+		t.Errorf("Expect error in func TestValuesN11(t *testing.T).\n%s", "my app")
+	}
+}
+
+var hostsQvx = newQvx(`
+tags:
+  a:
+    params: [href, rel, target]
+    required: [href]
+    values:
+      href: ["#link"]
+    external:
+      rel: nofollow ugc noopener
+      target: _blank
+  img:
+    short: true
+    params: [src]
+    required: [src]
+    values:
+      src: ["#link"]
+    hosts:
+      src: [cdn.dighub.ru]
+link_host_block: [evil.example]
+link_host_internal: [dighub.ru]
+`, nil)
+
+func TestValuesN12(t *testing.T) {
+	links := map[string]string{
+		`<a href="https://dighub.ru/page">a</a>`:      `<a href="https://dighub.ru/page">a</a>`,
+		`<a href="https://www.DigHub.ru/page">a</a>`:  `<a href="https://www.DigHub.ru/page">a</a>`,
+		`<a href="/page" target="_self">a</a>`:        `<a href="/page" target="_self">a</a>`,
+		`<a href="mailto:user@github.com">a</a>`:      `a`,
+		`<a href="https://github.com" rel="me">a</a>`: `<a href="https://github.com" rel="nofollow ugc noopener" target="_blank">a</a>`,
+		`<a href="//github.com">a</a>`:                `<a href="//github.com" rel="nofollow ugc noopener" target="_blank">a</a>`,
+		`<a href="https://notdighub.ru">a</a>`:        `<a href="https://notdighub.ru" rel="nofollow ugc noopener" target="_blank">a</a>`,
+		`<a href="https://evil.example/">a</a>`:       `a`,
+		`<a href="https://cdn.evil.example/">a</a>`:   `a`,
+		`<img src="https://cdn.dighub.ru/a.png">`:     `<img src="https://cdn.dighub.ru/a.png">`,
+		`<img src="/a.png">`:                          `<img src="/a.png">`,
+		`<img src="https://dighub.ru/a.png">`:         ``,
+		`http://github.com`:                           `<a href="http://github.com" rel="nofollow ugc noopener" target="_blank">http://github.com</a>`,
+		`http://dighub.ru`:                            `<a href="http://dighub.ru">http://dighub.ru</a>`,
+	}
+
+	for text, expect := range links {
+		result, _ := hostsQvx.Parse(text)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestValuesN12(t *testing.T).\n%s: %s", text, result)
+		}
+	}
+}
+
+func TestValuesN13(t *testing.T) {
+	result := hostsQvx.ParseResult(`<a href="https://evil.example/">a</a><a href="https://github.com" rel="me">b</a>`)
+
+	expect := []string{
+		`1:1: Тег <a> удален, содержимое оставлено: отсутствует обязательный атрибут`,
+		`1:4: Атрибут 'href' тега <a> удален: домен ссылки не разрешен`,
+		`1:67: Атрибут 'rel' тега <a> изменен на 'nofollow ugc noopener': значение задано правилами для внешних ссылок`,
+	}
+
+	if len(result.Report) != len(expect) {
+		t.Fatalf("Expect report to equal in func TestValuesN13(t *testing.T).\n%v", result.Report)
+	}
+
+	for i, entry := range result.Report {
+		if entry.String() != expect[i] {
+			t.Errorf("Expect report to equal in func TestValuesN13(t *testing.T).\n%s", entry)
+		}
+	}
+
+	if err := qevix.New().CfgSetLinkHostBlock([]string{"evil.example/path"}); err == nil {
+		t.Errorf("Expect error in func TestValuesN13(t *testing.T).\n%s", "evil.example/path")
+	}
+}
+
+func TestValuesN14(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"a", "img"})
+	cfg.CfgSetTagShort([]string{"img"})
+	cfg.CfgAllowTagParams("a", []string{"href"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+	cfg.CfgAllowTagParams("img", []string{"src"})
+	cfg.CfgAllowTagParamValue("img", "src", "#link")
+	cfg.CfgSetLinkHostInternal([]string{"dighub.ru"})
+	cfg.CfgSetLinkBaseURL("https://dighub.ru/blog/post/")
+	cfg.CfgSetLinkRewriteCallback(func(tag string, param string, link string, external bool) string {
+		if strings.Contains(link, "spam") {
+			return ""
+		}
+		if tag == "a" && external {
+			return "https://dighub.ru/away?to=" + url.QueryEscape(link)
+		}
+		return link
+	})
+
+	links := map[string]string{
+		`<a href="/page">a</a>`:                 `<a href="https://dighub.ru/page">a</a>`,
+		`<a href="../other?x=1&y=2">a</a>`:      `<a href="https://dighub.ru/blog/other?x=1&#38;y=2">a</a>`,
+		`<a href="#top">a</a>`:                  `<a href="#top">a</a>`,
+		`<a href="https://dighub.ru/x">a</a>`:   `<a href="https://dighub.ru/x">a</a>`,
+		`<a href="https://github.com/x">a</a>`:  `<a href="https://dighub.ru/away?to=https%3A%2F%2Fgithub.com%2Fx">a</a>`,
+		`<a href="//github.com/x">a</a>`:        `<a href="https://dighub.ru/away?to=https%3A%2F%2Fgithub.com%2Fx">a</a>`,
+		`<a href="https://spam.example/">a</a>`: `<a>a</a>`,
+		`<img src="./a.png">`:                   `<img src="https://dighub.ru/blog/post/a.png">`,
+		`<img src="https://github.com/a.png">`:  `<img src="https://github.com/a.png">`,
+		`http://github.com`:                     `<a href="https://dighub.ru/away?to=http%3A%2F%2Fgithub.com">http://github.com</a>`,
+	}
+
+	for text, expect := range links {
+		result, _ := cfg.Parse(text)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestValuesN14(t *testing.T).\n%s: %s", text, result)
+		}
+	}
+
+	if err := cfg.CfgSetLinkBaseURL("/relative"); err == nil {
+		t.Errorf("Expect error in func TestValuesN14(t *testing.T).\n%s", "/relative")
+	}
+}
+
+var globalQvx = newQvx("", func(cfg *qevix.Config) {
+	cfg.CfgAllowTags([]string{"p", "img", "b"})
+	cfg.CfgSetTagShort([]string{"img"})
+	cfg.CfgAllowTagParams("*", []string{"title", "lang", "aria-*", "data-*"})
+	cfg.CfgAllowTagParamValue("*", "lang", []string{"ru", "en"})
+	cfg.CfgAllowTagParamValue("*", "data-*", "#regexp(^[a-z0-9 ]+$)")
+	cfg.CfgAllowTagParams("img", []string{"src", "data-id"})
+	cfg.CfgAllowTagParamValue("img", "data-id", "#int")
+})
+
+func TestValuesN15(t *testing.T) {
+	text := `<p lang="ru" data-role="note" aria-label="Заметка" title="t" style="x">a</p>` +
+		`<img data-id="42" src="a.png" lang="de" aria-hidden="true"><img data-id="x" src="b.png"><b data-x="<>">b</b>`
+
+	expect := `<p title="t" lang="ru" aria-label="Заметка" data-role="note">a</p>` +
+		`<img src="a.png" data-id="42" aria-hidden="true"><img src="b.png"><b>b</b>`
+
+	result, errs := globalQvx.Parse(text)
+
+	if result != expect || len(errs) != 3 {
+		t.Errorf("Expect result to equal in func TestValuesN15(t *testing.T).\n%s\n%v", result, errs)
+	}
+}
+
+func TestValuesN16(t *testing.T) {
+	buff := bytes.NewBuffer(nil)
+	if err := globalQvx.WriteYAML(buff); err != nil {
+		t.Fatalf("Expect no error in func TestValuesN16(t *testing.T).\n%v", err)
+	}
+
+	cfg, err := qevix.LoadConfig(buff)
+	if err != nil {
+		t.Fatalf("Expect no error in func TestValuesN16(t *testing.T).\n%v", err)
+	}
+
+	text := `<p lang="ru" data-role="note" title="t">a</p>`
+
+	expect, _ := globalQvx.Parse(text)
+	result, _ := cfg.Parse(text)
+
+	if result != expect {
+		t.Errorf("Expect result to equal in func TestValuesN16(t *testing.T).\n%s", result)
+	}
+
+	if err := qevix.New().CfgAllowTags([]string{"*"}); err == nil {
+		t.Errorf("Expect error in func TestValuesN16(t *testing.T).\n%s", "*")
+	}
+
+	if err := qevix.New().CfgAllowTagParams("*", []string{"data*", "*"}); err == nil {
+		t.Errorf("Expect error in func TestValuesN16(t *testing.T).\n%s", "data*")
+	}
+}
+
+func TestValuesN17(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"code", "p", "span"})
+	cfg.CfgAllowTagParams("code", []string{"class"})
+	cfg.CfgAllowTagParamValue("code", "class", "#class(language-*)")
+	cfg.CfgAllowTagParams("p", []string{"class"})
+	cfg.CfgAllowTagParamValue("p", "class", "#class(align-left, align-right, note)")
+	cfg.CfgAllowTagParams("span", []string{"class"})
+	cfg.CfgAllowTagParamValue("span", "class", "#class")
+	cfg.CfgSetClassPrefix("user-")
+
+	texts := map[string]string{
+		`<code class="language-go">a</code>`:                    `<code class="user-language-go">a</code>`,
+		`<code class="language-go  admin language-go">a</code>`: `<code class="user-language-go">a</code>`,
+		`<code class="admin">a</code>`:                          `<code>a</code>`,
+		`<p class="note align-left hidden note">a</p>`:          `<p class="user-note user-align-left">a</p>`,
+		`<span class="any-Class _x 1bad">a</span>`:              `<span class="user-any-Class user-_x">a</span>`,
+		`<span class="a&quot;b">a</span>`:                       `<span>a</span>`,
+	}
+
+	for text, expect := range texts {
+		result, _ := cfg.Parse(text)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestValuesN17(t *testing.T).\n%s: %s", text, result)
+		}
+	}
+
+	if err := cfg.CfgAllowTagParamValue("p", "class", "#class(a b)"); err == nil {
+		t.Errorf("Expect error in func TestValuesN17(t *testing.T).\n%s", "#class(a b)")
+	}
+
+	if err := cfg.CfgSetClassPrefix("user prefix"); err == nil {
+		t.Errorf("Expect error in func TestValuesN17(t *testing.T).\n%s", "user prefix")
+	}
+}
+
+func TestValuesN18(t *testing.T) {
+	cfg := qevix.New()
+	cfg.CfgAllowTags([]string{"a", "h2"})
+	cfg.CfgAllowTagParams("a", []string{"href", "name"})
+	cfg.CfgAllowTagParamValue("a", "href", "#link")
+	cfg.CfgAllowTagParams("h2", []string{"id"})
+	cfg.CfgSetIDPrefix("user-content-")
+
+	texts := map[string]string{
+		`<h2 id="config">a</h2><a href="#config">b</a>`:       `<h2 id="user-content-config">a</h2><a href="#user-content-config">b</a>`,
+		`<h2 id="x">a</h2><h2 id="x">b</h2><a name="x">c</a>`: `<h2 id="user-content-x">a</h2><h2 id="user-content-x-1">b</h2><a name="user-content-x-2">c</a>`,
+		`<h2 id="user-content-y">a</h2>`:                      `<h2 id="user-content-y">a</h2>`,
+		`<h2 id="1 bad">a</h2>`:                               `<h2>a</h2>`,
+		`<a href="#user-content-z">a</a>`:                     `<a href="#user-content-z">a</a>`,
+		`<a href="http://example.com/#top">a</a>`:             `<a href="http://example.com/#top">a</a>`,
+	}
+
+	for text, expect := range texts {
+		result, _ := cfg.Parse(text)
+
+		if result != expect {
+			t.Errorf("Expect result to equal in func TestValuesN18(t *testing.T).\n%s: %s", text, result)
+		}
+	}
+
+	if err := cfg.CfgSetIDPrefix("user content"); err == nil {
+		t.Errorf("Expect error in func TestValuesN18(t *testing.T).\n%s", "user content")
+	}
+}
+
+func TestValuesN19(t *testing.T) {
+	tests := []struct {
+		base    string
+		rewrite string
+		links   map[string]string
+	}{
+		{"https://github.com/", "", map[string]string{
+			`<a href="/page">a</a>`: `<a href="https://github.com/page" rel="nofollow ugc noopener" target="_blank">a</a>`,
+			`<img src="/a.png">`:    ``,
+		}},
+		{"https://evil.example/", "", map[string]string{
+			`<a href="/page">a</a>`:                  `a`,
+			`<a href="https://dighub.ru/page">a</a>`: `<a href="https://dighub.ru/page">a</a>`,
+		}},
+		{"https://cdn.dighub.ru/", "https://evil.example/?u=", map[string]string{
+			`<a href="/page">a</a>`: `a`,
+			`<img src="/a.png">`:    ``,
+		}},
+	}
+
+	for _, test := range tests {
+		cfg := qevix.New()
+		cfg.CfgApplySpec(hostsQvx.Spec())
+		cfg.CfgSetLinkBaseURL(test.base)
+		if test.rewrite != "" {
+			rewrite := test.rewrite
+			cfg.CfgSetLinkRewriteCallback(func(tag string, param string, link string, external bool) string {
+				return rewrite + url.QueryEscape(link)
+			})
+		}
+
+		for text, expect := range test.links {
+			result, _ := cfg.Parse(text)
+
+			if result != expect {
+				t.Errorf("Expect result to equal in func TestValuesN19(t *testing.T).\n%s %s: %s", test.base, text, result)
+			}
+		}
+	}
+}
@@ -0,0 +1,726 @@
+package qevix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//
+// Поддерживается подмножество YAML, достаточное для описания политики:
+// вложенные карты и списки с отступами пробелами, списки "- значение",
+// однострочные списки [a, b] и карты {a: b}, строки в кавычках и без,
+// значения true/false/null, целые числа и комментарии "#".
+// Значение без кавычек не может начинаться с "#": такая строка считается ошибкой, а не комментарием.
+//
+
+//
+// Строка YAML документа
+//
+type yamlLine struct {
+	num    int    // Номер строки в документе
+	indent int    // Отступ
+	text   string // Текст строки без отступа и комментария
+}
+
+//
+// Разбор YAML документа
+//
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+//
+// Преобразует YAML документ в JSON
+//
+// data []byte - YAML документ
+// target reflect.Type - тип, в который будет декодирован JSON
+//
+func yamlToJSON(data []byte, target reflect.Type) ([]byte, error) {
+	p := &yamlParser{}
+
+	for i, text := range strings.Split(string(data), "\n") {
+		text, err := stripYAMLComment(text, i+1)
+		if err != nil {
+			return nil, err
+		}
+		text = strings.TrimRight(text, " \t\r")
+
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, yamlError(i+1, "табуляция в отступе не допускается")
+		}
+
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+
+	var value interface{} = map[string]interface{}{}
+	if len(p.lines) > 0 {
+		var err error
+		if value, err = p.parseBlock(p.lines[0].indent); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.lines) {
+			return nil, yamlError(p.lines[p.pos].num, "неверный отступ")
+		}
+	}
+
+	return json.Marshal(conformYAMLValue(value, target))
+}
+
+//
+// Приводит целые числа к строкам там, где тип назначения ожидает строку
+//
+// value interface{} - значение
+// target reflect.Type - тип назначения
+//
+func conformYAMLValue(value interface{}, target reflect.Type) interface{} {
+	for target.Kind() == reflect.Ptr {
+		target = target.Elem()
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		if target.Kind() == reflect.String {
+			return v.String()
+		}
+	case []interface{}:
+		if target.Kind() == reflect.Slice || target.Kind() == reflect.Array {
+			for i, item := range v {
+				v[i] = conformYAMLValue(item, target.Elem())
+			}
+		}
+	case map[string]interface{}:
+		switch target.Kind() {
+		case reflect.Map:
+			for key, item := range v {
+				v[key] = conformYAMLValue(item, target.Elem())
+			}
+		case reflect.Struct:
+			for i := 0; i < target.NumField(); i++ {
+				field := target.Field(i)
+				name := strings.Split(field.Tag.Get("json"), ",")[0]
+				if item, ok := v[name]; ok {
+					v[name] = conformYAMLValue(item, field.Type)
+				}
+			}
+		}
+	}
+
+	return value
+}
+
+//
+// Формирует ошибку разбора YAML
+//
+// num int - номер строки
+// msg string - сообщение
+//
+func yamlError(num int, msg string) error {
+	return errors.New("qevix: yaml: строка " + strconv.Itoa(num) + ": " + msg)
+}
+
+//
+// Удаляет комментарий из строки с учётом кавычек.
+// Комментарий без пробела после "#" на месте значения (key: #link) считается значением без кавычек и вызывает ошибку.
+//
+// text string - строка
+// num int - номер строки
+//
+func stripYAMLComment(text string, num int) (string, error) {
+	quote := rune(0)
+	prev := ' '
+	for i, char := range text {
+		switch {
+		case quote != 0 && char == quote && (quote == '\'' || prev != '\\'):
+			quote = 0
+		case quote == 0 && (char == '"' || char == '\''):
+			quote = char
+		case quote == 0 && char == '#' && (prev == ' ' || prev == '\t'):
+			if isYAMLValuePos(text[:i]) && i+1 < len(text) && text[i+1] != ' ' && text[i+1] != '\t' {
+				value := text[i:]
+				if end := strings.IndexAny(value, " \t,]}"); end > 0 {
+					value = value[:end]
+				}
+				return "", yamlError(num, "значение "+value+" начинается с '#' и должно быть в кавычках")
+			}
+			return text[:i], nil
+		}
+		prev = char
+	}
+	return text, nil
+}
+
+//
+// Ожидается ли после текста значение: после ключа, маркера списка или внутри однострочного списка и карты
+//
+// text string - текст строки перед символом
+//
+func isYAMLValuePos(text string) bool {
+	text = strings.TrimRight(text, " \t")
+	if text == "" {
+		return false
+	}
+	if text == "-" || strings.HasSuffix(text, " -") {
+		return true
+	}
+	return strings.IndexByte(":,[{", text[len(text)-1]) != -1
+}
+
+//
+// Является ли строка элементом списка
+//
+// text string - строка
+//
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+//
+// Разбирает блок с указанным отступом
+//
+// indent int - отступ блока
+//
+func (self *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isYAMLSeqItem(self.lines[self.pos].text) {
+		return self.parseSeq(indent)
+	}
+	return self.parseMap(indent)
+}
+
+//
+// Разбирает вложенный блок после ключа или элемента списка
+//
+// indent int - отступ родительского блока
+// allowSeq bool - допускается ли список на том же отступе
+//
+func (self *yamlParser) parseNested(indent int, allowSeq bool) (interface{}, error) {
+	if self.pos >= len(self.lines) {
+		return nil, nil
+	}
+
+	line := self.lines[self.pos]
+	switch {
+	case line.indent > indent:
+		return self.parseBlock(line.indent)
+	case allowSeq && line.indent == indent && isYAMLSeqItem(line.text):
+		return self.parseSeq(indent)
+	}
+
+	return nil, nil
+}
+
+//
+// Разбирает список
+//
+// indent int - отступ списка
+//
+func (self *yamlParser) parseSeq(indent int) (interface{}, error) {
+	items := []interface{}{}
+
+	for self.pos < len(self.lines) && self.lines[self.pos].indent == indent && isYAMLSeqItem(self.lines[self.pos].text) {
+		line := self.lines[self.pos]
+		rest := strings.TrimLeft(line.text[1:], " ")
+
+		var item interface{}
+		var err error
+
+		switch {
+		case rest == "":
+			self.pos++
+			item, err = self.parseNested(indent, false)
+		case isYAMLMapEntry(rest):
+			// Карта, начинающаяся в строке элемента списка: "- key: value"
+			offset := len(line.text) - len(rest)
+			self.lines[self.pos] = yamlLine{num: line.num, indent: indent + offset, text: rest}
+			item, err = self.parseMap(indent + offset)
+		default:
+			self.pos++
+			item, err = parseYAMLInline(rest, line.num)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	if self.pos < len(self.lines) && self.lines[self.pos].indent > indent {
+		return nil, yamlError(self.lines[self.pos].num, "неверный отступ")
+	}
+
+	return items, nil
+}
+
+//
+// Разбирает карту
+//
+// indent int - отступ карты
+//
+func (self *yamlParser) parseMap(indent int) (interface{}, error) {
+	items := map[string]interface{}{}
+
+	for self.pos < len(self.lines) && self.lines[self.pos].indent == indent {
+		line := self.lines[self.pos]
+
+		if isYAMLSeqItem(line.text) {
+			return nil, yamlError(line.num, "ожидался ключ")
+		}
+
+		key, rest, err := splitYAMLKey(line.text, line.num)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := items[key]; ok {
+			return nil, yamlError(line.num, "повторяющийся ключ '"+key+"'")
+		}
+
+		self.pos++
+
+		var value interface{}
+		if rest == "" {
+			value, err = self.parseNested(indent, true)
+		} else {
+			value, err = parseYAMLInline(rest, line.num)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		items[key] = value
+	}
+
+	if self.pos < len(self.lines) && self.lines[self.pos].indent > indent {
+		return nil, yamlError(self.lines[self.pos].num, "неверный отступ")
+	}
+
+	return items, nil
+}
+
+var yamlMapEntryRx = regexp.MustCompile(`^("(?:[^"\\]|\\.)*"|'(?:[^']|'')*'|[^\s"'\[\]{},#][^:]*?)\s*:(?:\s|$)`)
+
+//
+// Является ли строка парой "ключ: значение"
+//
+// text string - строка
+//
+func isYAMLMapEntry(text string) bool {
+	return yamlMapEntryRx.MatchString(text)
+}
+
+//
+// Разделяет строку на ключ и значение
+//
+// text string - строка
+// num int - номер строки
+//
+func splitYAMLKey(text string, num int) (string, string, error) {
+	mc := yamlMapEntryRx.FindStringSubmatch(text)
+	if mc == nil {
+		return "", "", yamlError(num, "ожидался ключ")
+	}
+
+	key, err := parseYAMLScalar(mc[1], num)
+	if err != nil {
+		return "", "", err
+	}
+
+	str, ok := key.(string)
+	if !ok {
+		str = mc[1]
+	}
+
+	return str, strings.TrimSpace(text[len(mc[0]):]), nil
+}
+
+//
+// Разбирает однострочное значение
+//
+// text string - значение
+// num int - номер строки
+//
+func parseYAMLInline(text string, num int) (interface{}, error) {
+	if text[0] != '[' && text[0] != '{' {
+		return parseYAMLScalar(text, num)
+	}
+
+	flow := &yamlFlow{text: text, num: num}
+
+	value, err := flow.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	flow.skipSpaces()
+	if flow.pos != len(flow.text) {
+		return nil, yamlError(num, "лишние символы после значения")
+	}
+
+	return value, nil
+}
+
+//
+// Разбирает скалярное значение
+//
+// text string - значение
+// num int - номер строки
+//
+func parseYAMLScalar(text string, num int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "\""):
+		str, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, yamlError(num, "неверная строка в кавычках "+text)
+		}
+		return str, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, yamlError(num, "неверная строка в кавычках "+text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	}
+
+	switch text {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null", "~":
+		return nil, nil
+	}
+
+	if yamlIntRx.MatchString(text) {
+		return json.Number(text), nil
+	}
+
+	return text, nil
+}
+
+//
+// Разбор однострочных списков и карт
+//
+type yamlFlow struct {
+	text string
+	pos  int
+	num  int
+}
+
+func (self *yamlFlow) skipSpaces() {
+	for self.pos < len(self.text) && self.text[self.pos] == ' ' {
+		self.pos++
+	}
+}
+
+func (self *yamlFlow) parse() (interface{}, error) {
+	self.skipSpaces()
+
+	if self.pos >= len(self.text) {
+		return nil, yamlError(self.num, "ожидалось значение")
+	}
+
+	switch self.text[self.pos] {
+	case '[':
+		return self.parseSeq()
+	case '{':
+		return self.parseMap()
+	}
+
+	return self.parseScalar(",]}")
+}
+
+func (self *yamlFlow) parseSeq() (interface{}, error) {
+	items := []interface{}{}
+
+	self.pos++
+	self.skipSpaces()
+
+	if self.pos < len(self.text) && self.text[self.pos] == ']' {
+		self.pos++
+		return items, nil
+	}
+
+	for {
+		item, err := self.parse()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		self.skipSpaces()
+		if self.pos >= len(self.text) {
+			return nil, yamlError(self.num, "ожидалось ']'")
+		}
+
+		switch self.text[self.pos] {
+		case ',':
+			self.pos++
+		case ']':
+			self.pos++
+			return items, nil
+		default:
+			return nil, yamlError(self.num, "ожидалось ',' или ']'")
+		}
+	}
+}
+
+func (self *yamlFlow) parseMap() (interface{}, error) {
+	items := map[string]interface{}{}
+
+	self.pos++
+	self.skipSpaces()
+
+	if self.pos < len(self.text) && self.text[self.pos] == '}' {
+		self.pos++
+		return items, nil
+	}
+
+	for {
+		self.skipSpaces()
+
+		key, err := self.parseScalar(":,}")
+		if err != nil {
+			return nil, err
+		}
+
+		str, ok := key.(string)
+		if !ok || self.pos >= len(self.text) || self.text[self.pos] != ':' {
+			return nil, yamlError(self.num, "ожидался ключ")
+		}
+		self.pos++
+
+		value, err := self.parse()
+		if err != nil {
+			return nil, err
+		}
+		items[str] = value
+
+		self.skipSpaces()
+		if self.pos >= len(self.text) {
+			return nil, yamlError(self.num, "ожидалось '}'")
+		}
+
+		switch self.text[self.pos] {
+		case ',':
+			self.pos++
+		case '}':
+			self.pos++
+			return items, nil
+		default:
+			return nil, yamlError(self.num, "ожидалось ',' или '}'")
+		}
+	}
+}
+
+func (self *yamlFlow) parseScalar(stop string) (interface{}, error) {
+	start := self.pos
+
+	if self.pos < len(self.text) && (self.text[self.pos] == '"' || self.text[self.pos] == '\'') {
+		quote := self.text[self.pos]
+		self.pos++
+		for self.pos < len(self.text) {
+			if quote == '"' && self.text[self.pos] == '\\' {
+				self.pos += 2
+				continue
+			}
+			if self.text[self.pos] == quote {
+				if quote == '\'' && self.pos+1 < len(self.text) && self.text[self.pos+1] == '\'' {
+					self.pos += 2
+					continue
+				}
+				break
+			}
+			self.pos++
+		}
+		self.pos++
+		if self.pos > len(self.text) {
+			return nil, yamlError(self.num, "незакрытая кавычка")
+		}
+		value, err := parseYAMLScalar(self.text[start:self.pos], self.num)
+		self.skipSpaces()
+		return value, err
+	}
+
+	for self.pos < len(self.text) && strings.IndexByte(stop, self.text[self.pos]) == -1 {
+		self.pos++
+	}
+
+	return parseYAMLScalar(strings.TrimSpace(self.text[start:self.pos]), self.num)
+}
+
+//
+// Узел документа с сохранением порядка ключей
+//
+type yamlNode struct {
+	isMap  bool
+	isSeq  bool
+	keys   []string
+	items  []*yamlNode
+	scalar string
+}
+
+//
+// Преобразует JSON в YAML с сохранением порядка ключей
+//
+// data []byte - JSON документ
+//
+func jsonToYAML(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	node, err := decodeYAMLNode(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	buff := bytes.NewBufferString("")
+	if node.isMap && len(node.keys) > 0 {
+		writeYAMLMap(buff, node, 0)
+	} else {
+		buff.WriteString(formatYAMLInline(node) + "\n")
+	}
+
+	return buff.Bytes(), nil
+}
+
+//
+// Считывает узел из потока JSON
+//
+func decodeYAMLNode(decoder *json.Decoder) (*yamlNode, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &yamlNode{}
+
+	switch v := token.(type) {
+	case json.Delim:
+		node.isMap = (v == '{')
+		node.isSeq = (v == '[')
+
+		for decoder.More() {
+			if node.isMap {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.keys = append(node.keys, key.(string))
+			}
+
+			item, err := decodeYAMLNode(decoder)
+			if err != nil {
+				return nil, err
+			}
+			node.items = append(node.items, item)
+		}
+
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	case string:
+		node.scalar = strconv.Quote(v)
+	case json.Number:
+		node.scalar = v.String()
+	case bool:
+		node.scalar = strconv.FormatBool(v)
+	case nil:
+		node.scalar = "null"
+	}
+
+	return node, nil
+}
+
+var yamlIntRx = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+
+var yamlPlainKeyRx = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.\-]*$`)
+
+//
+// Можно ли записать узел в одну строку
+//
+func isYAMLInline(node *yamlNode) bool {
+	if len(node.items) == 0 {
+		return true
+	}
+	if node.isMap {
+		return false
+	}
+	for _, item := range node.items {
+		if item.isMap || item.isSeq {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// Записывает узел в одну строку
+//
+func formatYAMLInline(node *yamlNode) string {
+	switch {
+	case node.isMap:
+		return "{}"
+	case node.isSeq:
+		items := make([]string, len(node.items))
+		for i, item := range node.items {
+			items[i] = item.scalar
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return node.scalar
+}
+
+//
+// Записывает карту блоком
+//
+func writeYAMLMap(w io.Writer, node *yamlNode, indent int) {
+	prefix := strings.Repeat(" ", indent)
+
+	for i, key := range node.keys {
+		if !yamlPlainKeyRx.MatchString(key) {
+			key = strconv.Quote(key)
+		}
+
+		item := node.items[i]
+		if isYAMLInline(item) {
+			io.WriteString(w, prefix+key+": "+formatYAMLInline(item)+"\n")
+			continue
+		}
+
+		io.WriteString(w, prefix+key+":\n")
+		writeYAMLBlock(w, item, indent+2)
+	}
+}
+
+//
+// Записывает узел блоком
+//
+func writeYAMLBlock(w io.Writer, node *yamlNode, indent int) {
+	if node.isMap {
+		writeYAMLMap(w, node, indent)
+		return
+	}
+
+	prefix := strings.Repeat(" ", indent)
+	for _, item := range node.items {
+		if isYAMLInline(item) {
+			io.WriteString(w, prefix+"- "+formatYAMLInline(item)+"\n")
+			continue
+		}
+
+		io.WriteString(w, prefix+"-\n")
+		writeYAMLBlock(w, item, indent+2)
+	}
+}